@@ -0,0 +1,304 @@
+// Package acme obtains certificates from ACME v2 directories using DNS-01
+// challenges and keeps the account and certificates in a state directory.
+package acme
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	xacme "golang.org/x/crypto/acme"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const LetsEncryptURL = xacme.LetsEncryptURL
+
+type Config struct {
+	DirectoryURL string
+	Email        string
+	EABKeyID     string
+	EABHMACKey   []byte
+	StateDir     string
+	KeyType      string
+	HTTPClient   *http.Client
+	Provider     DNSProvider
+	// Resolver is the address of a DNS server that is polled until the
+	// challenge records are visible before the challenges are accepted.
+	Resolver           string
+	PropagationTimeout time.Duration
+}
+
+type Client struct {
+	config Config
+	client *xacme.Client
+}
+
+type accountState struct {
+	DirectoryURL string `json:"directory_url"`
+	URI          string `json:"uri"`
+}
+
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case "", "rsa2048":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "rsa3072":
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case "rsa4096":
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case "ec256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ec384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unknown key type %q", keyType)
+	}
+}
+
+func encodeKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func loadOrCreateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		var key crypto.Signer
+		if key, err = generateKey("ec256"); err != nil {
+			return nil, err
+		}
+		if data, err = encodeKey(key); err != nil {
+			return nil, err
+		}
+		return key, os.WriteFile(path, data, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s contains an unsupported key", path)
+	}
+	return signer, nil
+}
+
+// NewClient loads the account key from the state directory, creating both if
+// they do not exist yet.
+func NewClient(config Config) (*Client, error) {
+	if config.DirectoryURL == "" {
+		config.DirectoryURL = LetsEncryptURL
+	}
+	if config.PropagationTimeout == 0 {
+		config.PropagationTimeout = 2 * time.Minute
+	}
+	if err := os.MkdirAll(filepath.Join(config.StateDir, "certificates"), 0700); err != nil {
+		return nil, err
+	}
+	key, err := loadOrCreateKey(filepath.Join(config.StateDir, "account.key"))
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		config: config,
+		client: &xacme.Client{
+			Key:          key,
+			DirectoryURL: config.DirectoryURL,
+			HTTPClient:   config.HTTPClient,
+			UserAgent:    "fritzbox-client",
+		},
+	}, nil
+}
+
+func (c *Client) accountPath() string {
+	return filepath.Join(c.config.StateDir, "account.json")
+}
+
+// register makes sure an account exists for the account key, creating it
+// with the configured contact and external account binding if necessary.
+func (c *Client) register(ctx context.Context) error {
+	var state accountState
+	if data, err := os.ReadFile(c.accountPath()); err == nil {
+		if err = json.Unmarshal(data, &state); err != nil {
+			return err
+		}
+		if state.DirectoryURL == c.config.DirectoryURL && state.URI != "" {
+			c.client.KID = xacme.KeyID(state.URI)
+			return nil
+		}
+	}
+
+	account, err := c.client.GetReg(ctx, "")
+	if errors.Is(err, xacme.ErrNoAccount) {
+		account = &xacme.Account{}
+		if c.config.Email != "" {
+			account.Contact = []string{"mailto:" + c.config.Email}
+		}
+		if c.config.EABKeyID != "" {
+			account.ExternalAccountBinding = &xacme.ExternalAccountBinding{
+				KID: c.config.EABKeyID,
+				Key: c.config.EABHMACKey,
+			}
+		}
+		account, err = c.client.Register(ctx, account, xacme.AcceptTOS)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to register ACME account: %w", err)
+	}
+
+	data, err := json.Marshal(accountState{DirectoryURL: c.config.DirectoryURL, URI: account.URI})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.accountPath(), data, 0600)
+}
+
+func (c *Client) certificatePaths(domains []string) (string, string) {
+	name := strings.ReplaceAll(domains[0], "*", "_")
+	base := filepath.Join(c.config.StateDir, "certificates", name)
+	return base + ".crt", base + ".key"
+}
+
+// Load returns the stored certificate chain and key for the domains.
+func (c *Client) Load(domains []string) ([]byte, []byte, error) {
+	certificatePath, keyPath := c.certificatePaths(domains)
+	certificate, err := os.ReadFile(certificatePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certificate, key, nil
+}
+
+func (c *Client) solve(ctx context.Context, authorizationURL string, cleanups *[]func()) error {
+	authorization, err := c.client.GetAuthorization(ctx, authorizationURL)
+	if err != nil {
+		return err
+	}
+	if authorization.Status == xacme.StatusValid {
+		return nil
+	}
+	var challenge *xacme.Challenge
+	for _, candidate := range authorization.Challenges {
+		if candidate.Type == "dns-01" {
+			challenge = candidate
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("no dns-01 challenge offered for %s", authorization.Identifier.Value)
+	}
+
+	value, err := c.client.DNS01ChallengeRecord(challenge.Token)
+	if err != nil {
+		return err
+	}
+	fqdn := "_acme-challenge." + authorization.Identifier.Value
+	if err = c.config.Provider.Present(ctx, fqdn, value); err != nil {
+		return err
+	}
+	*cleanups = append(*cleanups, func() {
+		_ = c.config.Provider.CleanUp(context.Background(), fqdn, value)
+	})
+	if c.config.Resolver != "" {
+		if err = waitForRecord(ctx, c.config.Resolver, fqdn, value, c.config.PropagationTimeout); err != nil {
+			return err
+		}
+	}
+
+	if _, err = c.client.Accept(ctx, challenge); err != nil {
+		return err
+	}
+	if _, err = c.client.WaitAuthorization(ctx, authorization.URI); err != nil {
+		return fmt.Errorf("authorization of %s failed: %w", authorization.Identifier.Value, err)
+	}
+	return nil
+}
+
+// Obtain orders a new certificate for the domains, solves the DNS-01
+// challenges through the configured provider and stores certificate chain
+// and key in the state directory.
+func (c *Client) Obtain(ctx context.Context, domains []string) ([]byte, []byte, error) {
+	if err := c.register(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	order, err := c.client.AuthorizeOrder(ctx, xacme.DomainIDs(domains...))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var cleanups []func()
+	defer func() {
+		for _, cleanup := range cleanups {
+			cleanup()
+		}
+	}()
+	for _, authorizationURL := range order.AuthzURLs {
+		if err = c.solve(ctx, authorizationURL, &cleanups); err != nil {
+			return nil, nil, err
+		}
+	}
+	if order, err = c.client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, nil, err
+	}
+
+	key, err := generateKey(c.config.KeyType)
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	chain, _, err := c.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var certificate []byte
+	for _, der := range chain {
+		certificate = append(certificate, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyData, err := encodeKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certificatePath, keyPath := c.certificatePaths(domains)
+	if err = os.WriteFile(keyPath, keyData, 0600); err != nil {
+		return nil, nil, err
+	}
+	if err = os.WriteFile(certificatePath, certificate, 0644); err != nil {
+		return nil, nil, err
+	}
+	return certificate, keyData, nil
+}
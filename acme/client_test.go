@@ -0,0 +1,181 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/letsencrypt/pebble/v2/ca"
+	"github.com/letsencrypt/pebble/v2/db"
+	"github.com/letsencrypt/pebble/v2/va"
+	"github.com/letsencrypt/pebble/v2/wfe"
+	"github.com/miekg/dns"
+	"io"
+	"log"
+	"net"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testZone is a name server that accepts dynamic updates of TXT records, as
+// used by RFC2136Provider, and answers queries for them.
+type testZone struct {
+	mutex   sync.Mutex
+	records map[string][]string
+	address string
+}
+
+func (z *testZone) ServeDNS(w dns.ResponseWriter, request *dns.Msg) {
+	reply := new(dns.Msg)
+	reply.SetReply(request)
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	if request.Opcode == dns.OpcodeUpdate {
+		for _, record := range request.Ns {
+			txt, ok := record.(*dns.TXT)
+			if !ok {
+				continue
+			}
+			name := strings.ToLower(txt.Hdr.Name)
+			if txt.Hdr.Class == dns.ClassNONE {
+				z.records[name] = slices.DeleteFunc(z.records[name], func(value string) bool {
+					return slices.Contains(txt.Txt, value)
+				})
+			} else {
+				z.records[name] = append(z.records[name], txt.Txt...)
+			}
+		}
+	} else {
+		for _, question := range request.Question {
+			name := strings.ToLower(question.Name)
+			if question.Qtype != dns.TypeTXT {
+				continue
+			}
+			for _, value := range z.records[name] {
+				reply.Answer = append(reply.Answer, &dns.TXT{
+					Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+					Txt: []string{value},
+				})
+			}
+		}
+	}
+	_ = w.WriteMsg(reply)
+}
+
+// startTestZone serves the zone over UDP and TCP on the same port.
+func startTestZone(t *testing.T) *testZone {
+	zone := &testZone{records: make(map[string][]string)}
+	for attempt := 0; attempt < 10; attempt++ {
+		packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener, err := net.Listen("tcp", packetConn.LocalAddr().String())
+		if err != nil {
+			_ = packetConn.Close()
+			continue
+		}
+		zone.address = packetConn.LocalAddr().String()
+		// The default accept function rejects updates.
+		accept := func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept }
+		udpServer := &dns.Server{PacketConn: packetConn, Handler: zone, MsgAcceptFunc: accept}
+		tcpServer := &dns.Server{Listener: listener, Handler: zone, MsgAcceptFunc: accept}
+		go func() { _ = udpServer.ActivateAndServe() }()
+		go func() { _ = tcpServer.ActivateAndServe() }()
+		t.Cleanup(func() {
+			_ = udpServer.Shutdown()
+			_ = tcpServer.Shutdown()
+		})
+		return zone
+	}
+	t.Fatal("no free port for the test zone")
+	return nil
+}
+
+// startPebble runs the Pebble ACME test server in process, validating DNS-01
+// challenges against the resolver.
+func startPebble(t *testing.T, resolver string) *httptest.Server {
+	t.Setenv("PEBBLE_VA_NOSLEEP", "1")
+	t.Setenv("PEBBLE_WFE_NONCEREJECT", "0")
+	logger := log.New(io.Discard, "", 0)
+	store := db.NewMemoryStore()
+	authority := ca.New(logger, store, "", 0, 1, 0)
+	validation := va.New(logger, 0, 0, false, resolver, store)
+	frontend := wfe.New(logger, store, validation, authority, false, false, 3, 5)
+	server := httptest.NewTLSServer(frontend.Handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestObtainWithPebble(t *testing.T) {
+	zone := startTestZone(t)
+	server := startPebble(t, zone.address)
+
+	config := Config{
+		DirectoryURL: server.URL + wfe.DirectoryPath,
+		Email:        "admin@example.com",
+		StateDir:     t.TempDir(),
+		KeyType:      "ec256",
+		HTTPClient:   server.Client(),
+		Provider: RFC2136Provider{
+			Server: zone.address,
+			Zone:   "example.com",
+		},
+		Resolver:           zone.address,
+		PropagationTimeout: 10 * time.Second,
+	}
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	domains := []string{"fritz.example.com", "box.example.com"}
+	certificate, key, err := client.Obtain(ctx, domains)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode(certificate)
+	if block == nil {
+		t.Fatal("no certificate returned")
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, domain := range domains {
+		if err = leaf.VerifyHostname(domain); err != nil {
+			t.Error(err)
+		}
+	}
+
+	zone.mutex.Lock()
+	for name, values := range zone.records {
+		if len(values) > 0 {
+			t.Errorf("challenge record %s was not cleaned up", name)
+		}
+	}
+	zone.mutex.Unlock()
+
+	storedCertificate, storedKey, err := client.Load(domains)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(storedCertificate, certificate) || !bytes.Equal(storedKey, key) {
+		t.Error("stored certificate and key differ from the obtained ones")
+	}
+
+	// A second client reuses the stored account.
+	if client, err = NewClient(config); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = client.Obtain(ctx, domains[:1]); err != nil {
+		t.Fatal(err)
+	}
+}
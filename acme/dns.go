@@ -0,0 +1,132 @@
+package acme
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"time"
+)
+
+// DNSProvider publishes and removes the TXT records of DNS-01 challenges.
+type DNSProvider interface {
+	Present(ctx context.Context, fqdn string, value string) error
+	CleanUp(ctx context.Context, fqdn string, value string) error
+}
+
+// ExecProvider runs an external command as `command present|cleanup FQDN
+// VALUE` to manage the challenge records, e.g. a script calling the API of a
+// DNS hoster.
+type ExecProvider struct {
+	Command string
+}
+
+func (p ExecProvider) run(ctx context.Context, action string, fqdn string, value string) error {
+	cmd := exec.CommandContext(ctx, p.Command, action, fqdn, value)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "ACME_ACTION="+action, "ACME_FQDN="+fqdn, "ACME_VALUE="+value)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook failed: %w", action, err)
+	}
+	return nil
+}
+
+func (p ExecProvider) Present(ctx context.Context, fqdn string, value string) error {
+	return p.run(ctx, "present", fqdn, value)
+}
+
+func (p ExecProvider) CleanUp(ctx context.Context, fqdn string, value string) error {
+	return p.run(ctx, "cleanup", fqdn, value)
+}
+
+// RFC2136Provider manages the challenge records through dynamic DNS updates,
+// optionally authenticated with TSIG.
+type RFC2136Provider struct {
+	Server        string
+	Zone          string
+	TSIGName      string
+	TSIGSecret    string
+	TSIGAlgorithm string
+	TTL           uint32
+}
+
+func (p RFC2136Provider) update(ctx context.Context, fqdn string, value string, remove bool) error {
+	record := &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   dns.Fqdn(fqdn),
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+			Ttl:    p.TTL,
+		},
+		Txt: []string{value},
+	}
+	if record.Hdr.Ttl == 0 {
+		record.Hdr.Ttl = 60
+	}
+
+	message := new(dns.Msg)
+	message.SetUpdate(dns.Fqdn(p.Zone))
+	if remove {
+		message.Remove([]dns.RR{record})
+	} else {
+		message.Insert([]dns.RR{record})
+	}
+
+	client := new(dns.Client)
+	client.Net = "tcp"
+	if p.TSIGName != "" {
+		algorithm := p.TSIGAlgorithm
+		if algorithm == "" {
+			algorithm = dns.HmacSHA256
+		}
+		message.SetTsig(dns.Fqdn(p.TSIGName), dns.Fqdn(algorithm), 300, time.Now().Unix())
+		client.TsigSecret = map[string]string{dns.Fqdn(p.TSIGName): p.TSIGSecret}
+	}
+
+	reply, _, err := client.ExchangeContext(ctx, message, p.Server)
+	if err != nil {
+		return err
+	}
+	if reply.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("dns update for %s failed: %s", fqdn, dns.RcodeToString[reply.Rcode])
+	}
+	return nil
+}
+
+func (p RFC2136Provider) Present(ctx context.Context, fqdn string, value string) error {
+	return p.update(ctx, fqdn, value, false)
+}
+
+func (p RFC2136Provider) CleanUp(ctx context.Context, fqdn string, value string) error {
+	return p.update(ctx, fqdn, value, true)
+}
+
+// waitForRecord polls the given resolver until the TXT record with the
+// challenge value is visible.
+func waitForRecord(ctx context.Context, resolverAddress string, fqdn string, value string, timeout time.Duration) error {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, resolverAddress)
+		},
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		records, _ := resolver.LookupTXT(ctx, fqdn)
+		if slices.Contains(records, value) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.New("timed out waiting for TXT record of " + fqdn)
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
@@ -2,9 +2,16 @@ package api
 
 import (
 	"encoding/xml"
+	"errors"
 	"fmt"
 )
 
+var ErrNotDeletable = errors.New("phone number cannot be deleted")
+
+var ErrNotConfirmed = errors.New("the operation was not confirmed on the box")
+
+var ErrNotRegistered = errors.New("phone number is not registered")
+
 type SessionID string
 
 type SessionInfo struct {
@@ -49,92 +56,99 @@ type ValErrorResult struct {
 }
 
 type PhoneNumber struct {
-	Number           string          `json:"number"`
-	OutboundProxy    string          `json:"outboundproxy"`
-	Active           bool            `json:"active"`
-	ProviderName     string          `json:"providername"`
-	CountTrunk       int             `json:"count_trunk"`
-	Deletable        bool            `json:"deletable"`
-	MsnNumber        string          `json:"msnnum"`
-	AreaCode         string          `json:"number1"`
-	LocalNumber      string          `json:"number2"`
-	Sip              SipData         `json:"sip"`
-	Type             string          `json:"type"`
-	Mode             string          `json:"mode"`
-	Id               string          `json:"id"`
-	WebUiTrunkId     string          `json:"webui_trunk:id"`
-	Registrar        string          `json:"registrar"`
-	TelConfig        TelephoneConfig `json:"telcfg"`
-	Uid              string          `json:"uid"`
-	TelConfigId      string          `json:"telcfg_id"`
-	ParentProviderId string          `json:"parentprovider_id"`
-	ProviderId       string          `json:"provider_id"`
-	Registered       bool            `json:"registered"`
-	GuiReadonly      string          `json:"gui_readonly"`
-	Name             string          `json:"name"`
+	Number           string          `json:"number" yaml:"number"`
+	OutboundProxy    string          `json:"outboundproxy" yaml:"outboundproxy"`
+	Active           FlexBool        `json:"active" yaml:"active"`
+	ProviderName     string          `json:"providername" yaml:"providername"`
+	CountTrunk       int             `json:"count_trunk" yaml:"count_trunk"`
+	Deletable        FlexBool        `json:"deletable" yaml:"deletable"`
+	MsnNumber        string          `json:"msnnum" yaml:"msnnum"`
+	AreaCode         string          `json:"number1" yaml:"number1"`
+	LocalNumber      string          `json:"number2" yaml:"number2"`
+	Sip              SipData         `json:"sip" yaml:"sip"`
+	Type             string          `json:"type" yaml:"type"`
+	Mode             string          `json:"mode" yaml:"mode"`
+	Id               string          `json:"id" yaml:"id"`
+	WebUiTrunkId     string          `json:"webui_trunk:id" yaml:"webui_trunk:id"`
+	Registrar        string          `json:"registrar" yaml:"registrar"`
+	TelConfig        TelephoneConfig `json:"telcfg" yaml:"telcfg"`
+	Uid              string          `json:"uid" yaml:"uid"`
+	TelConfigId      string          `json:"telcfg_id" yaml:"telcfg_id"`
+	ParentProviderId string          `json:"parentprovider_id" yaml:"parentprovider_id"`
+	ProviderId       string          `json:"provider_id" yaml:"provider_id"`
+	Registered       FlexBool        `json:"registered" yaml:"registered"`
+	GuiReadonly      FlexBool        `json:"gui_readonly" yaml:"gui_readonly"`
+	Name             string          `json:"name" yaml:"name"`
+}
+
+type SipProvider struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	Registrar string `json:"registrar"`
+	Proxy     string `json:"outboundproxy"`
 }
 
 type SipData struct {
-	OutboundProxyWithoutRouteHeader string `json:"outboundproxy_without_route_header"`
-	ProviderName                    string `json:"providername"`
-	MwiSupported                    string `json:"mwi_supported"`
-	ProtocolPrefer                  string `json:"protocolprefer"`
-	Username                        string `json:"username"`
-	Trunk                           string `json:"Trunk"`
-	UseInternatCallingNumber        string `json:"use_internat_calling_numb"`
-	DoNotRegister                   string `json:"do_not_register"`
-	Reception                       string `json:"Reception"`
-	ExtensionLength                 string `json:"ExtensionLength"`
-	TransportType                   string `json:"transport_type"`
-	Registrar                       string `json:"registrar"`
-	ClirType                        string `json:"clirtype"`
-	G726ViaRfc3551                  string `json:"g726_via_rfc3551_"`
-	ShowProtocolPrefer              bool   `json:"showprotocolprefer"`
-	CallDeflection                  string `json:"call_deflection"`
-	OutboundProxy                   string `json:"outboundproxy"`
-	VoipProviderListId              string `json:"voip_providerlist_id"`
-	DisplayName                     string `json:"displayname"`
-	EncryptionEnabled               string `json:"encryption_enabled"`
-	CryptoAvpMode                   string `json:"crypto_avp_mode"`
-	SrtpSupported                   string `json:"srtp_supported"`
-	TxPacketSizeInMs                string `json:"tx_packetsize_in_ms"`
-	Node                            string `json:"_node"`
-	NoRegisterFetch                 string `json:"no_register_fetch"`
-	CcbsSupported                   string `json:"ccbs_supported"`
-	ReadPAssertedIdentityHeader     string `json:"read_p_asserted_identity_header"`
-	ID                              string `json:"ID"`
-	DTMFConfig                      string `json:"dtmfcfg"`
-	OriginStunServer                string `json:"origin_stunserver"`
-	StunServer                      string `json:"stunserver"`
-	RouteAlwaysOverInternet         string `json:"route_always_over_internet"`
-	OriginOutboundProxy             string `json:"origin_outboundproxy"`
-	OriginRegistrar                 string `json:"origin_registrar"`
-	OriginUsername                  string `json:"origin_username"`
-	Mode                            string `json:"mode"`
-	WebUiTrunkId                    string `json:"webui_trunk_id"`
-	ClipNsType                      string `json:"clipnstype"`
-	DdiType                         string `json:"dditype"`
-	Password                        string `json:"password"`
-	VoipOverMobile                  string `json:"voip_over_mobile"`
-	SippingInterval                 string `json:"sipping_interval"`
-	Registered                      string `json:"registered"`
-	AuthnameNeeded                  string `json:"authname_needed"`
-	Authname                        string `json:"authname"`
-	GuiReadonly                     string `json:"gui_readonly"`
-	Activated                       string `json:"activated"`
+	OutboundProxyWithoutRouteHeader FlexBool      `json:"outboundproxy_without_route_header" yaml:"outboundproxy_without_route_header"`
+	ProviderName                    string        `json:"providername" yaml:"providername"`
+	MwiSupported                    FlexBool      `json:"mwi_supported" yaml:"mwi_supported"`
+	ProtocolPrefer                  string        `json:"protocolprefer" yaml:"protocolprefer"`
+	Username                        string        `json:"username" yaml:"username"`
+	Trunk                           string        `json:"Trunk" yaml:"Trunk"`
+	UseInternatCallingNumber        FlexBool      `json:"use_internat_calling_numb" yaml:"use_internat_calling_numb"`
+	DoNotRegister                   FlexBool      `json:"do_not_register" yaml:"do_not_register"`
+	Reception                       string        `json:"Reception" yaml:"Reception"`
+	ExtensionLength                 string        `json:"ExtensionLength" yaml:"ExtensionLength"`
+	TransportType                   TransportType `json:"transport_type" yaml:"transport_type"`
+	Registrar                       string        `json:"registrar" yaml:"registrar"`
+	ClirType                        ClirType      `json:"clirtype" yaml:"clirtype"`
+	G726ViaRfc3551                  FlexBool      `json:"g726_via_rfc3551_" yaml:"g726_via_rfc3551_"`
+	ShowProtocolPrefer              FlexBool      `json:"showprotocolprefer" yaml:"showprotocolprefer"`
+	CallDeflection                  FlexBool      `json:"call_deflection" yaml:"call_deflection"`
+	OutboundProxy                   string        `json:"outboundproxy" yaml:"outboundproxy"`
+	VoipProviderListId              string        `json:"voip_providerlist_id" yaml:"voip_providerlist_id"`
+	DisplayName                     string        `json:"displayname" yaml:"displayname"`
+	EncryptionEnabled               FlexBool      `json:"encryption_enabled" yaml:"encryption_enabled"`
+	CryptoAvpMode                   SrtpMode      `json:"crypto_avp_mode" yaml:"crypto_avp_mode"`
+	SrtpSupported                   FlexBool      `json:"srtp_supported" yaml:"srtp_supported"`
+	TxPacketSizeInMs                string        `json:"tx_packetsize_in_ms" yaml:"tx_packetsize_in_ms"`
+	Node                            string        `json:"_node" yaml:"_node"`
+	NoRegisterFetch                 FlexBool      `json:"no_register_fetch" yaml:"no_register_fetch"`
+	CcbsSupported                   FlexBool      `json:"ccbs_supported" yaml:"ccbs_supported"`
+	ReadPAssertedIdentityHeader     FlexBool      `json:"read_p_asserted_identity_header" yaml:"read_p_asserted_identity_header"`
+	ID                              string        `json:"ID" yaml:"ID"`
+	DTMFConfig                      DtmfMode      `json:"dtmfcfg" yaml:"dtmfcfg"`
+	OriginStunServer                string        `json:"origin_stunserver" yaml:"origin_stunserver"`
+	StunServer                      string        `json:"stunserver" yaml:"stunserver"`
+	RouteAlwaysOverInternet         FlexBool      `json:"route_always_over_internet" yaml:"route_always_over_internet"`
+	OriginOutboundProxy             string        `json:"origin_outboundproxy" yaml:"origin_outboundproxy"`
+	OriginRegistrar                 string        `json:"origin_registrar" yaml:"origin_registrar"`
+	OriginUsername                  string        `json:"origin_username" yaml:"origin_username"`
+	Mode                            string        `json:"mode" yaml:"mode"`
+	WebUiTrunkId                    string        `json:"webui_trunk_id" yaml:"webui_trunk_id"`
+	ClipNsType                      string        `json:"clipnstype" yaml:"clipnstype"`
+	DdiType                         string        `json:"dditype" yaml:"dditype"`
+	Password                        string        `json:"password" yaml:"password"`
+	VoipOverMobile                  FlexBool      `json:"voip_over_mobile" yaml:"voip_over_mobile"`
+	SippingInterval                 string        `json:"sipping_interval" yaml:"sipping_interval"`
+	Registered                      FlexBool      `json:"registered" yaml:"registered"`
+	AuthnameNeeded                  FlexBool      `json:"authname_needed" yaml:"authname_needed"`
+	Authname                        string        `json:"authname" yaml:"authname"`
+	GuiReadonly                     FlexBool      `json:"gui_readonly" yaml:"gui_readonly"`
+	Activated                       FlexBool      `json:"activated" yaml:"activated"`
 }
 
 type TelephoneConfig struct {
-	RegistryType    string `json:"RegistryType"`
-	AKN             string `json:"AKN"`
-	EmergencyRule   string `json:"EmergencyRule"`
-	KeepLKZPrefix   string `json:"KeepLKZPrefix"`
-	KeepOKZPrefix   string `json:"KeepOKZPrefix"`
-	Suffix          string `json:"Suffix"`
-	ClipNoScreening string `json:"ClipNoScreening"`
-	AlternatePrefix string `json:"AlternatePrefix"`
-	UseOKZ          string `json:"UseOKZ"`
-	UseLKZ          string `json:"UseLKZ"`
+	RegistryType    string   `json:"RegistryType" yaml:"RegistryType"`
+	AKN             string   `json:"AKN" yaml:"AKN"`
+	EmergencyRule   string   `json:"EmergencyRule" yaml:"EmergencyRule"`
+	KeepLKZPrefix   FlexBool `json:"KeepLKZPrefix" yaml:"KeepLKZPrefix"`
+	KeepOKZPrefix   FlexBool `json:"KeepOKZPrefix" yaml:"KeepOKZPrefix"`
+	Suffix          string   `json:"Suffix" yaml:"Suffix"`
+	ClipNoScreening FlexBool `json:"ClipNoScreening" yaml:"ClipNoScreening"`
+	AlternatePrefix string   `json:"AlternatePrefix" yaml:"AlternatePrefix"`
+	UseOKZ          FlexBool `json:"UseOKZ" yaml:"UseOKZ"`
+	UseLKZ          FlexBool `json:"UseLKZ" yaml:"UseLKZ"`
 }
 
 func (m *SessionAccess) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ExportConfiguration downloads the configuration export of the box. Secrets
+// in the export are encrypted with the given password, which is required to
+// restore them.
+func (c *FritzboxClient) ExportConfiguration(id SessionID, password string) ([]byte, error) {
+	resp, err := c.postFirmwarecfg([]formPart{
+		{Name: "sid", Value: string(id)},
+		{Name: "ImExportPassword", Value: password},
+		{Name: "ConfigExport"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status while exporting configuration: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// RestoreSection is a part of the configuration that can be restored on its
+// own. Firmware versions without partial restore reject the selection.
+type RestoreSection string
+
+const (
+	RestoreInternet  RestoreSection = "internet"
+	RestoreTelephony RestoreSection = "telephony"
+	RestoreWLAN      RestoreSection = "wlan"
+	RestoreDECT      RestoreSection = "dect"
+	RestoreSmartHome RestoreSection = "smarthome"
+)
+
+// restoreSectionFields are the checkboxes of the partial restore form.
+var restoreSectionFields = map[RestoreSection]string{
+	RestoreInternet:  "ImportInternet",
+	RestoreTelephony: "ImportTelephony",
+	RestoreWLAN:      "ImportWLAN",
+	RestoreDECT:      "ImportDECT",
+	RestoreSmartHome: "ImportSmartHome",
+}
+
+// RestoreSections lists the sections in the order of the restore form.
+var RestoreSections = []RestoreSection{RestoreInternet, RestoreTelephony, RestoreWLAN, RestoreDECT, RestoreSmartHome}
+
+// RestoreOptions control ImportConfiguration. Without Sections, the whole
+// configuration is restored. With Wait set, ImportConfiguration waits until
+// the box is reachable again after the restart the restore causes.
+type RestoreOptions struct {
+	Password string
+	Sections []RestoreSection
+	// Confirm is called if the box asks for a confirmation of the restore
+	// (two-factor authentication), before waiting up to ConfirmationTimeout
+	// for it to be given on the box.
+	Confirm             func()
+	ConfirmationTimeout time.Duration
+	Wait                bool
+	Timeout             time.Duration
+}
+
+type RestoreResult struct {
+	Message   string
+	Confirmed bool
+	Ready     ReadyReport
+}
+
+// twoFactorState is the state of a pending confirmation as reported by
+// twofactor.lua.
+type twoFactorState struct {
+	Active bool `json:"active"`
+	Done   bool `json:"done"`
+}
+
+var twoFactorSelector = cascadia.MustCompile("[data-twofactor], #uiTwofactor")
+
+func (c *FritzboxClient) waitTwoFactor(id SessionID, timeout time.Duration) error {
+	if timeout == 0 {
+		timeout = 2 * time.Minute
+	}
+	requestUrl := c.baseUrl.JoinPath("/twofactor.lua")
+	query := requestUrl.Query()
+	query.Set("sid", string(id))
+	query.Set("tfa_active", "")
+	requestUrl.RawQuery = query.Encode()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(2 * time.Second)
+		resp, err := c.httpClient.Get(requestUrl.String())
+		if err != nil {
+			return err
+		}
+		var state twoFactorState
+		err = json.NewDecoder(resp.Body).Decode(&state)
+		_ = resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if state.Done {
+			return nil
+		}
+		if !state.Active {
+			return ErrNotConfirmed
+		}
+	}
+	return fmt.Errorf("%w within %s", ErrNotConfirmed, timeout)
+}
+
+// ImportConfiguration restores a configuration export created with the given
+// password. The content is closed after the upload if it is an io.Closer.
+func (c *FritzboxClient) ImportConfiguration(id SessionID, content io.Reader, options RestoreOptions) (RestoreResult, error) {
+	var result RestoreResult
+	parts := []formPart{
+		{Name: "sid", Value: string(id)},
+		{Name: "ImExportPassword", Value: options.Password},
+	}
+	if len(options.Sections) > 0 {
+		parts = append(parts, formPart{Name: "ImportPartial", Value: "1"})
+		for _, section := range options.Sections {
+			field, ok := restoreSectionFields[section]
+			if !ok {
+				closeFormParts([]formPart{{Content: content}})
+				return result, fmt.Errorf("unknown restore section %q", section)
+			}
+			parts = append(parts, formPart{Name: field, Value: "on"})
+		}
+	}
+	parts = append(parts,
+		formPart{Name: "ConfigImportFile", FileName: "box.export", Content: content},
+		formPart{Name: "apply"},
+	)
+
+	resp, err := c.postFirmwarecfg(parts)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return result, fmt.Errorf("unexpected status while restoring configuration: %s", resp.Status)
+	}
+	var document *html.Node
+	document, result.Message, err = parseUploadMessage(resp)
+	if document == nil {
+		return result, err
+	}
+
+	// The confirmation page does not show an update message.
+	if cascadia.Query(document, twoFactorSelector) != nil {
+		if options.Confirm != nil {
+			options.Confirm()
+		}
+		if err = c.waitTwoFactor(id, options.ConfirmationTimeout); err != nil {
+			return result, err
+		}
+		result.Confirmed = true
+	} else if err != nil {
+		return result, err
+	}
+
+	if options.Wait {
+		if result.Ready, err = c.WaitReady(ReadyOptions{DownWithin: time.Minute, Timeout: options.Timeout}); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// BoxInfo is the device information the box publishes without login in
+// jason_boxinfo.xml.
+type BoxInfo struct {
+	Name       string `xml:"Name" json:"name"`
+	HWRevision string `xml:"HW" json:"hw_revision"`
+	Version    string `xml:"Version" json:"version"`
+	Revision   string `xml:"Revision" json:"revision"`
+	Serial     string `xml:"Serial" json:"serial"`
+	OEM        string `xml:"OEM" json:"oem"`
+	Language   string `xml:"Lang" json:"language"`
+	Annex      string `xml:"Annex" json:"annex"`
+	Lab        string `xml:"Lab" json:"lab"`
+	Country    string `xml:"Country" json:"country"`
+}
+
+// GetBoxInfo reads the box info through the REST API after a login and from
+// jason_boxinfo.xml otherwise, or if the session is no longer valid, e.g.
+// after a restart.
+func (c *FritzboxClient) GetBoxInfo() (BoxInfo, error) {
+	if c.useRest(c.sid) {
+		if info, err := c.restGetBoxInfo(); err == nil {
+			return info, nil
+		}
+	}
+
+	var info BoxInfo
+	resp, err := c.httpClient.Get(c.baseUrl.JoinPath("/jason_boxinfo.xml").String())
+	if err != nil {
+		return info, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return info, fmt.Errorf("unexpected status while retrieving box info: %s", resp.Status)
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&info)
+	return info, err
+}
+
+const (
+	deviceInfoService = "urn:dslforum-org:service:DeviceInfo:1"
+	deviceInfoPath    = "/upnp/control/deviceinfo"
+)
+
+// DeviceInfo is the device information of the TR-064 DeviceInfo service,
+// which requires a login.
+type DeviceInfo struct {
+	ModelName       string
+	SerialNumber    string
+	SoftwareVersion string
+	HardwareVersion string
+	Uptime          time.Duration
+}
+
+func (c *FritzboxClient) GetDeviceInfo() (DeviceInfo, error) {
+	var info DeviceInfo
+	result, err := c.tr064Call(deviceInfoService, deviceInfoPath, "GetInfo", nil)
+	if err != nil {
+		return info, err
+	}
+	info.ModelName = result["NewModelName"]
+	info.SerialNumber = result["NewSerialNumber"]
+	info.SoftwareVersion = result["NewSoftwareVersion"]
+	info.HardwareVersion = result["NewHardwareVersion"]
+	if uptime, err := strconv.Atoi(result["NewUpTime"]); err == nil {
+		info.Uptime = time.Duration(uptime) * time.Second
+	}
+	return info, nil
+}
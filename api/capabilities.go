@@ -0,0 +1,128 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// OSVersion is a FRITZ!OS version like 7.57. The zero value means the version
+// has not been detected.
+type OSVersion struct {
+	Major int
+	Minor int
+}
+
+// ParseOSVersion parses the firmware version of the box, e.g. 154.07.57, where
+// the first part identifies the model.
+func ParseOSVersion(version string) (OSVersion, error) {
+	parts := strings.Split(version, ".")
+	if len(parts) < 2 {
+		return OSVersion{}, fmt.Errorf("invalid firmware version %q", version)
+	}
+	parts = parts[len(parts)-2:]
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return OSVersion{}, fmt.Errorf("invalid firmware version %q", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return OSVersion{}, fmt.Errorf("invalid firmware version %q", version)
+	}
+	return OSVersion{Major: major, Minor: minor}, nil
+}
+
+func (v OSVersion) String() string {
+	if v == (OSVersion{}) {
+		return "unknown"
+	}
+	return fmt.Sprintf("%d.%02d", v.Major, v.Minor)
+}
+
+func (v OSVersion) AtLeast(other OSVersion) bool {
+	return v.Major > other.Major || (v.Major == other.Major && v.Minor >= other.Minor)
+}
+
+// Capability is a feature of the web interface or TR-064 that depends on
+// the FRITZ!OS version.
+type Capability string
+
+const (
+	// CapabilitySipEditJSON is the JSON variant of the sip_edit page, older
+	// versions embed the data in JavaScript.
+	CapabilitySipEditJSON Capability = "sip_edit_json"
+	// CapabilityECDSACertificates is support for ECDSA keys in certificate
+	// uploads.
+	CapabilityECDSACertificates Capability = "ecdsa_certificates"
+	// CapabilityRestAPI is the JSON REST API under /api/v0, which replaces
+	// scraping the Lua pages where available.
+	CapabilityRestAPI Capability = "rest_api"
+)
+
+// capabilityVersions is the registry of the versions that introduced each
+// capability. Features every supported firmware offers, like the sip_edit
+// page itself, are no capabilities and never rejected.
+var capabilityVersions = map[Capability]OSVersion{
+	// Only preferred, boxes below stay on the JavaScript page format. 7.50
+	// is the first version the JSON variant is known to decode on.
+	CapabilitySipEditJSON: {Major: 7, Minor: 50},
+	// FRITZ!OS 7.50 introduced ECDSA keys for the box certificate; older
+	// versions reject the upload.
+	CapabilityECDSACertificates: {Major: 7, Minor: 50},
+	// Only preferred, every REST request falls back to data.lua. /api/v0
+	// first appeared with the web interface of FRITZ!OS 7.50.
+	CapabilityRestAPI: {Major: 7, Minor: 50},
+}
+
+// Capabilities lists all known capabilities.
+var Capabilities = []Capability{CapabilitySipEditJSON, CapabilityECDSACertificates, CapabilityRestAPI}
+
+// UnsupportedError is returned if an operation requires a capability the
+// firmware of the box lacks.
+type UnsupportedError struct {
+	Capability Capability
+	Version    OSVersion
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s is unsupported on FRITZ!OS %s, it requires %s or later", e.Capability, e.Version, capabilityVersions[e.Capability])
+}
+
+// Detect retrieves the box info and remembers the FRITZ!OS version to pick
+// the strategies for it. Login detects the version automatically.
+func (c *FritzboxClient) Detect() (BoxInfo, error) {
+	info, err := c.GetBoxInfo()
+	if err != nil {
+		return info, err
+	}
+	if c.version, err = ParseOSVersion(info.Version); err != nil {
+		return info, err
+	}
+	return info, nil
+}
+
+// Version returns the detected FRITZ!OS version.
+func (c *FritzboxClient) Version() OSVersion {
+	return c.version
+}
+
+// Supports reports whether the box supports the capability. If the version
+// has not been detected, the newest behaviour is assumed.
+func (c *FritzboxClient) Supports(capability Capability) bool {
+	return c.version == (OSVersion{}) || c.version.AtLeast(capabilityVersions[capability])
+}
+
+// prefers reports whether a strategy that depends on the capability should be
+// tried. Unlike Supports, it requires the version to be detected, so boxes of
+// unknown version stay on the established strategy.
+func (c *FritzboxClient) prefers(capability Capability) bool {
+	return c.version != (OSVersion{}) && c.version.AtLeast(capabilityVersions[capability])
+}
+
+// require fails with an UnsupportedError if the box lacks the capability.
+func (c *FritzboxClient) require(capability Capability) error {
+	if !c.Supports(capability) {
+		return &UnsupportedError{Capability: capability, Version: c.version}
+	}
+	return nil
+}
@@ -0,0 +1,548 @@
+package api
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"software.sslmate.com/src/go-pkcs12"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoCertificate       = errors.New("no certificate found")
+	ErrNoKey               = errors.New("no private key found")
+	ErrKeyPasswordRequired = errors.New("private key is encrypted, but no key password was given")
+	ErrKeyMismatch         = errors.New("private key does not match the certificate")
+	ErrCertificateExpired  = errors.New("certificate has expired")
+	ErrCertificateNotYet   = errors.New("certificate is not valid yet")
+	ErrHostnameMismatch    = errors.New("certificate does not cover hostname")
+	ErrUnsupportedKey      = errors.New("unsupported key")
+	ErrNotVerified         = errors.New("uploaded certificate is not served by the box")
+)
+
+// CertificatePolicy describes what the box accepts and which names a
+// certificate has to cover.
+type CertificatePolicy struct {
+	Hostnames  []string
+	AllowECDSA bool
+	MinRSABits int
+	MaxRSABits int
+	Now        time.Time
+}
+
+// CertificateUpdateOptions controls the verification after a certificate
+// upload. With Verify set, UpdateTLSCertificate waits until the box serves
+// the Expected chain, leaf first, or any certificate other than the previous
+// one if Expected is empty.
+type CertificateUpdateOptions struct {
+	Verify   bool
+	Expected []*x509.Certificate
+	Timeout  time.Duration
+}
+
+type CertificateUpdateResult struct {
+	Message        string
+	Verified       bool
+	OldFingerprint string
+	OldExpiry      time.Time
+	NewFingerprint string
+	NewExpiry      time.Time
+}
+
+func DefaultCertificatePolicy() CertificatePolicy {
+	return CertificatePolicy{
+		AllowECDSA: true,
+		MinRSABits: 2048,
+		MaxRSABits: 4096,
+	}
+}
+
+func decodePEMBlocks(data []byte) []*pem.Block {
+	var blocks []*pem.Block
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return blocks
+		}
+		blocks = append(blocks, block)
+	}
+}
+
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+	}
+	return signer, nil
+}
+
+// parsePrivateKeyBlock parses a PEM private key, decrypting legacy encrypted
+// and encrypted PKCS#8 keys with the given password.
+func parsePrivateKeyBlock(block *pem.Block, password string) (crypto.Signer, error) {
+	der := block.Bytes
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		if password == "" {
+			return nil, ErrKeyPasswordRequired
+		}
+		var err error
+		if der, err = decryptPKCS8(der, password); err != nil {
+			return nil, err
+		}
+		return parsePrivateKey(der)
+	}
+	// The FRITZ!Box itself only understands legacy PEM encryption, which is
+	// why the deprecated functions are used here.
+	if x509.IsEncryptedPEMBlock(block) {
+		if password == "" {
+			return nil, ErrKeyPasswordRequired
+		}
+		var err error
+		if der, err = x509.DecryptPEMBlock(block, []byte(password)); err != nil {
+			return nil, fmt.Errorf("unable to decrypt private key: %w", err)
+		}
+	}
+	return parsePrivateKey(der)
+}
+
+func checkKeyType(publicKey crypto.PublicKey, policy CertificatePolicy) error {
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		bits := publicKey.N.BitLen()
+		if policy.MinRSABits != 0 && bits < policy.MinRSABits {
+			return fmt.Errorf("%w: RSA key with %d bits is too small, at least %d bits are required", ErrUnsupportedKey, bits, policy.MinRSABits)
+		}
+		if policy.MaxRSABits != 0 && bits > policy.MaxRSABits {
+			return fmt.Errorf("%w: RSA key with %d bits is too large, at most %d bits are supported", ErrUnsupportedKey, bits, policy.MaxRSABits)
+		}
+	case *ecdsa.PublicKey:
+		if !policy.AllowECDSA {
+			return fmt.Errorf("%w: ECDSA keys are not supported by this firmware", ErrUnsupportedKey)
+		}
+		if publicKey.Curve != elliptic.P256() && publicKey.Curve != elliptic.P384() {
+			return fmt.Errorf("%w: ECDSA curve %s is not supported", ErrUnsupportedKey, publicKey.Curve.Params().Name)
+		}
+	default:
+		return fmt.Errorf("%w: %T", ErrUnsupportedKey, publicKey)
+	}
+	return nil
+}
+
+// CertificateBundle is a certificate chain, leaf first, together with its
+// private key. Key is nil if the key could not be parsed, it is then uploaded
+// as is. Keys with legacy PEM encryption keep their original PEM block, which
+// is uploaded as long as Passphrase is not changed.
+type CertificateBundle struct {
+	Chain      []*x509.Certificate
+	Key        crypto.Signer
+	Passphrase string
+	Stripped   []string
+	// keyBlock is the original PEM block of an encrypted or unparsable key,
+	// keyPassphrase the passphrase it is encrypted with.
+	keyBlock      *pem.Block
+	keyPassphrase string
+	// keyErr is the error parsing the key, reported by Validate.
+	keyErr error
+}
+
+func (b CertificateBundle) Leaf() *x509.Certificate {
+	return b.Chain[0]
+}
+
+func marshalTraditionalKey(key crypto.Signer) (*pem.Block, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}, nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, nil
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+	}
+}
+
+// KeyPEM returns the private key in the traditional PEM format the box
+// accepts, encrypted with Passphrase if one is set.
+func (b CertificateBundle) KeyPEM() ([]byte, error) {
+	if b.keyBlock != nil && (b.Key == nil || b.Passphrase == b.keyPassphrase) {
+		return pem.EncodeToMemory(b.keyBlock), nil
+	}
+	block, err := marshalTraditionalKey(b.Key)
+	if err != nil {
+		return nil, err
+	}
+	if b.Passphrase != "" {
+		if block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte(b.Passphrase), x509.PEMCipherAES256); err != nil {
+			return nil, err
+		}
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// ChainPEM returns the leaf certificate followed by its intermediates.
+func (b CertificateBundle) ChainPEM() []byte {
+	var data []byte
+	for _, certificate := range b.Chain {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})...)
+	}
+	return data
+}
+
+// PEM returns the bundle in the order the box accepts: leaf, intermediates
+// and key.
+func (b CertificateBundle) PEM() ([]byte, error) {
+	key, err := b.KeyPEM()
+	if err != nil {
+		return nil, err
+	}
+	return append(b.ChainPEM(), key...), nil
+}
+
+// orderChain returns the leaf followed by the intermediates that lead to its
+// root, dropping the self-signed root and unrelated certificates.
+func orderChain(leaf *x509.Certificate, certificates []*x509.Certificate) []*x509.Certificate {
+	chain := []*x509.Certificate{leaf}
+	current := leaf
+	for !bytes.Equal(current.RawIssuer, current.RawSubject) {
+		var next *x509.Certificate
+		for _, certificate := range certificates {
+			if slices.Contains(chain, certificate) || !bytes.Equal(certificate.RawSubject, current.RawIssuer) {
+				continue
+			}
+			if current.CheckSignatureFrom(certificate) == nil {
+				next = certificate
+				break
+			}
+		}
+		if next == nil || bytes.Equal(next.RawIssuer, next.RawSubject) {
+			break
+		}
+		chain = append(chain, next)
+		current = next
+	}
+	return chain
+}
+
+// setKey replaces the private key of the bundle. block is the original PEM
+// block of an encrypted or unparsable key, err the error parsing it.
+func (b *CertificateBundle) setKey(key crypto.Signer, block *pem.Block, passphrase string, err error) {
+	b.Key = key
+	b.keyBlock = block
+	b.keyPassphrase = passphrase
+	b.Passphrase = passphrase
+	b.keyErr = err
+}
+
+func keyMatches(certificate *x509.Certificate, key crypto.Signer) bool {
+	publicKey, ok := certificate.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && publicKey.Equal(key.Public())
+}
+
+// LoadCertificateBundle collects certificates and the private key from the
+// given inputs, which may be PEM files in any order, DER encoded certificates
+// or keys, or PKCS#12 bundles. The password is used for PKCS#12 bundles and
+// encrypted keys. PEM blocks other than certificates and keys are dropped and
+// listed in Stripped. A key that cannot be parsed or does not match the leaf
+// is reported by Validate.
+func LoadCertificateBundle(inputs [][]byte, password string) (CertificateBundle, error) {
+	var bundle CertificateBundle
+	var certificates []*x509.Certificate
+	addCertificate := func(certificate *x509.Certificate) {
+		if !slices.ContainsFunc(certificates, certificate.Equal) {
+			certificates = append(certificates, certificate)
+		}
+	}
+
+	for _, input := range inputs {
+		blocks := decodePEMBlocks(input)
+		if len(blocks) == 0 {
+			if parsed, err := x509.ParseCertificates(input); err == nil && len(parsed) > 0 {
+				for _, certificate := range parsed {
+					addCertificate(certificate)
+				}
+			} else if key, err := parsePrivateKey(input); err == nil {
+				bundle.setKey(key, nil, "", nil)
+			} else if key, leaf, intermediates, err := pkcs12.DecodeChain(input, password); err == nil {
+				signer, ok := key.(crypto.Signer)
+				if !ok {
+					return bundle, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+				}
+				bundle.setKey(signer, nil, "", nil)
+				addCertificate(leaf)
+				for _, certificate := range intermediates {
+					addCertificate(certificate)
+				}
+			} else if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+				return bundle, fmt.Errorf("unable to decode PKCS#12 bundle: %w", err)
+			} else {
+				return bundle, errors.New("input is neither PEM, DER nor PKCS#12")
+			}
+			continue
+		}
+		for _, block := range blocks {
+			switch {
+			case block.Type == "CERTIFICATE":
+				certificate, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return bundle, err
+				}
+				addCertificate(certificate)
+			case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+				key, err := parsePrivateKeyBlock(block, password)
+				switch {
+				case block.Type == "ENCRYPTED PRIVATE KEY":
+					// The box cannot read encrypted PKCS#8 keys, so they are
+					// only uploaded re-encoded by KeyPEM, encrypted with the
+					// same password.
+					if err != nil {
+						return bundle, err
+					}
+					bundle.setKey(key, nil, password, nil)
+				case x509.IsEncryptedPEMBlock(block):
+					bundle.setKey(key, block, password, err)
+				case key == nil:
+					bundle.setKey(nil, block, "", err)
+				default:
+					bundle.setKey(key, nil, "", nil)
+				}
+			default:
+				bundle.Stripped = append(bundle.Stripped, block.Type)
+			}
+		}
+	}
+
+	if len(certificates) == 0 {
+		return bundle, ErrNoCertificate
+	}
+	if bundle.Key == nil && bundle.keyBlock == nil {
+		return bundle, ErrNoKey
+	}
+
+	var leaf *x509.Certificate
+	if bundle.Key != nil {
+		for _, certificate := range certificates {
+			if keyMatches(certificate, bundle.Key) {
+				leaf = certificate
+				break
+			}
+		}
+	}
+	if leaf == nil {
+		// Without a matching key, the leaf is the certificate that did not
+		// issue any of the others. Validate reports the mismatch.
+		leaf = certificates[0]
+		for _, certificate := range certificates {
+			if !slices.ContainsFunc(certificates, func(other *x509.Certificate) bool {
+				return other != certificate && bytes.Equal(other.RawIssuer, certificate.RawSubject)
+			}) {
+				leaf = certificate
+				break
+			}
+		}
+	}
+	bundle.Chain = orderChain(leaf, certificates)
+	return bundle, nil
+}
+
+// Validate checks the leaf certificate and key of the bundle against the
+// policy before they are uploaded to the box.
+func (b CertificateBundle) Validate(policy CertificatePolicy) error {
+	leaf := b.Leaf()
+	if b.keyErr != nil {
+		return b.keyErr
+	}
+	if b.Key != nil && !keyMatches(leaf, b.Key) {
+		return ErrKeyMismatch
+	}
+	now := policy.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("%w on %s", ErrCertificateExpired, leaf.NotAfter.Format(time.RFC3339))
+	}
+	if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("%w before %s", ErrCertificateNotYet, leaf.NotBefore.Format(time.RFC3339))
+	}
+	for _, hostname := range policy.Hostnames {
+		if err := leaf.VerifyHostname(hostname); err != nil {
+			return fmt.Errorf("%w %s, it is valid for %s", ErrHostnameMismatch, hostname, strings.Join(leaf.DNSNames, ", "))
+		}
+	}
+	return checkKeyType(leaf.PublicKey, policy)
+}
+
+// ValidateCertificate checks a certificate and key before they are uploaded
+// to the box and returns the leaf certificate.
+func ValidateCertificate(certificateData []byte, keyData []byte, keyPassword string, policy CertificatePolicy) (*x509.Certificate, error) {
+	bundle, err := LoadCertificateBundle([][]byte{certificateData, keyData}, keyPassword)
+	if err != nil {
+		return nil, err
+	}
+	return bundle.Leaf(), bundle.Validate(policy)
+}
+
+func formatFingerprint(sum []byte) string {
+	encoded := strings.ToUpper(hex.EncodeToString(sum))
+	parts := make([]string, 0, len(sum))
+	for i := 0; i < len(encoded); i += 2 {
+		parts = append(parts, encoded[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+// CertificateFingerprint returns the SHA-256 fingerprint of a certificate in
+// the colon separated form openssl prints.
+func CertificateFingerprint(certificate *x509.Certificate) string {
+	sum := sha256.Sum256(certificate.Raw)
+	return formatFingerprint(sum[:])
+}
+
+// CertificateFingerprintSHA1 returns the SHA-1 fingerprint of a certificate,
+// as still shown by many browsers and the FRITZ!Box web interface.
+func CertificateFingerprintSHA1(certificate *x509.Certificate) string {
+	sum := sha1.Sum(certificate.Raw)
+	return formatFingerprint(sum[:])
+}
+
+func (c *FritzboxClient) tlsAddress() string {
+	port := c.baseUrl.Port()
+	if port == "" || c.baseUrl.Scheme != "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(c.baseUrl.Hostname(), port)
+}
+
+// ServedCertificates performs a TLS handshake with the box and returns the
+// certificate chain it serves, leaf first. The chain is not verified.
+func (c *FritzboxClient) ServedCertificates() ([]*x509.Certificate, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", c.tlsAddress(), &tls.Config{
+		ServerName:         c.baseUrl.Hostname(),
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	certificates := conn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return nil, ErrNoCertificate
+	}
+	return certificates, nil
+}
+
+// sameChain reports whether the served chain consists of exactly the expected
+// certificates in the same order.
+func sameChain(served []*x509.Certificate, expected []*x509.Certificate) bool {
+	return slices.EqualFunc(served, expected, func(a, b *x509.Certificate) bool {
+		return a.Equal(b)
+	})
+}
+
+func (c *FritzboxClient) verifyServedCertificate(result *CertificateUpdateResult, options CertificateUpdateOptions) error {
+	if len(options.Expected) == 0 && result.OldFingerprint == "" {
+		return fmt.Errorf("%w: neither the expected nor the previous certificate is known", ErrNotVerified)
+	}
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = 2 * time.Minute
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		served, err := c.ServedCertificates()
+		if err != nil {
+			// The web server restarts to load the new certificate.
+			if _, readyErr := c.WaitReady(ReadyOptions{Timeout: max(time.Until(deadline), time.Second)}); readyErr == nil {
+				served, err = c.ServedCertificates()
+			}
+		}
+		if err == nil {
+			result.NewFingerprint = CertificateFingerprint(served[0])
+			result.NewExpiry = served[0].NotAfter
+			if len(options.Expected) > 0 && sameChain(served, options.Expected) {
+				result.Verified = true
+				return nil
+			}
+			if len(options.Expected) == 0 && result.NewFingerprint != result.OldFingerprint {
+				result.Verified = true
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("%w within %s: %w", ErrNotVerified, timeout, err)
+			}
+			if len(options.Expected) > 0 && result.NewFingerprint == CertificateFingerprint(options.Expected[0]) {
+				return fmt.Errorf("%w within %s, it serves the certificate with a different chain", ErrNotVerified, timeout)
+			}
+			return fmt.Errorf("%w within %s, it still serves %s", ErrNotVerified, timeout, result.NewFingerprint)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// DownloadTLSCertificate downloads the certificate chain installed on the box
+// through the certificate download of the web interface.
+func (c *FritzboxClient) DownloadTLSCertificate(id SessionID) ([]*x509.Certificate, error) {
+	resp, err := c.postFirmwarecfg([]formPart{
+		{Name: "sid", Value: string(id)},
+		{Name: "BoxCertExport"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status while downloading certificate: %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var certificates []*x509.Certificate
+	for _, block := range decodePEMBlocks(data) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+	if len(certificates) == 0 {
+		return nil, ErrNoCertificate
+	}
+	return certificates, nil
+}
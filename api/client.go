@@ -3,20 +3,32 @@ package api
 import (
 	"bufio"
 	"bytes"
+	"crypto/ecdsa"
 	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
+	"time"
 )
 
 type FritzboxClient struct {
 	baseUrl    *url.URL
 	httpClient *http.Client
+	// username and password of the last login, for TR-064 actions which
+	// authenticate every request.
+	username string
+	password string
+	// sid is the session of the last login, for the REST API.
+	sid SessionID
+	// version is the detected FRITZ!OS version, see Detect.
+	version OSVersion
+	// restUnavailable is set once the REST API turned out to be missing.
+	restUnavailable bool
 }
 
 func NewClient(baseUrl string) (FritzboxClient, error) {
@@ -31,10 +43,13 @@ func NewClient(baseUrl string) (FritzboxClient, error) {
 }
 
 func (c *FritzboxClient) getSessionInfo() (SessionInfo, error) {
-	resp, err := c.httpClient.Get(c.baseUrl.JoinPath("/login_sid.lua").String())
+	requestUrl := c.baseUrl.JoinPath("/login_sid.lua")
+	requestUrl.RawQuery = url.Values{"version": {"2"}}.Encode()
+	resp, err := c.httpClient.Get(requestUrl.String())
 	if err != nil {
 		return SessionInfo{}, err
 	}
+	defer resp.Body.Close()
 	var result SessionInfo
 	err = xml.NewDecoder(resp.Body).Decode(&result)
 	return result, err
@@ -46,11 +61,13 @@ func (c *FritzboxClient) challengeResponseLogin(sid SessionID, username string,
 	query.Set("sid", string(sid))
 	query.Set("username", username)
 	query.Set("response", response)
+	query.Set("version", "2")
 	requestUrl.RawQuery = query.Encode()
 	resp, err := c.httpClient.Get(requestUrl.String())
 	if err != nil {
 		return SessionInfo{}, err
 	}
+	defer resp.Body.Close()
 	var result SessionInfo
 	err = xml.NewDecoder(resp.Body).Decode(&result)
 	if err != nil {
@@ -62,65 +79,80 @@ func (c *FritzboxClient) challengeResponseLogin(sid SessionID, username string,
 	return result, nil
 }
 
+// Login logs in with the PBKDF2 challenge-response if the box offers it and
+// with MD5 otherwise. It detects the FRITZ!OS version first if Detect was not
+// called before.
 func (c *FritzboxClient) Login(username string, password string) (SessionInfo, error) {
+	if c.version == (OSVersion{}) {
+		_, _ = c.Detect()
+	}
 	sessionInfo, err := c.getSessionInfo()
 	if err != nil {
 		return SessionInfo{}, err
 	}
-	response := fmt.Sprintf("%s-%s", sessionInfo.Challenge, challengeResponse(sessionInfo.Challenge, password))
-	return c.challengeResponseLogin(sessionInfo.Sid, username, response)
-}
-
-func generateCertificateBody(multipartWriter *multipart.Writer, id SessionID, password string, files []io.ReadCloser) error {
-	var err error
-	var fieldWriter io.Writer
-	if fieldWriter, err = multipartWriter.CreateFormField("sid"); err != nil {
-		return err
-	}
-	if _, err = io.WriteString(fieldWriter, string(id)); err != nil {
-		return err
+	var response string
+	if strings.HasPrefix(sessionInfo.Challenge, "2$") {
+		if response, err = pbkdf2ChallengeResponse(sessionInfo.Challenge, password); err != nil {
+			return SessionInfo{}, err
+		}
+	} else {
+		response = fmt.Sprintf("%s-%s", sessionInfo.Challenge, challengeResponse(sessionInfo.Challenge, password))
 	}
-	if fieldWriter, err = multipartWriter.CreateFormField("BoxCertPassword"); err != nil {
-		return err
+	if sessionInfo, err = c.challengeResponseLogin(sessionInfo.Sid, username, response); err != nil {
+		return SessionInfo{}, err
 	}
-	if _, err = io.WriteString(fieldWriter, password); err != nil {
-		return err
+	c.username, c.password = username, password
+	c.sid = sessionInfo.Sid
+	return sessionInfo, nil
+}
+
+// UpdateTLSCertificate uploads the certificate bundle, protecting the key with
+// its passphrase if one is set.
+func (c *FritzboxClient) UpdateTLSCertificate(id SessionID, bundle CertificateBundle, options CertificateUpdateOptions) (CertificateUpdateResult, error) {
+	var result CertificateUpdateResult
+	if len(bundle.Chain) > 0 {
+		if _, ok := bundle.Leaf().PublicKey.(*ecdsa.PublicKey); ok {
+			if err := c.require(CapabilityECDSACertificates); err != nil {
+				return result, err
+			}
+		}
 	}
-	if fieldWriter, err = multipartWriter.CreateFormFile("BoxCertImportFile", "BoxCert.pem"); err != nil {
-		return err
+	if len(options.Expected) == 0 {
+		options.Expected = bundle.Chain
 	}
-	for _, file := range files {
-		if _, err = io.Copy(fieldWriter, file); err != nil {
-			return err
+	if options.Verify {
+		if served, err := c.ServedCertificates(); err == nil {
+			result.OldFingerprint = CertificateFingerprint(served[0])
+			result.OldExpiry = served[0].NotAfter
 		}
 	}
-	if err = multipartWriter.Close(); err != nil {
-		return err
-	}
-	return nil
-}
 
-func (c *FritzboxClient) UpdateTLSCertificate(id SessionID, password string, files []io.ReadCloser) (string, error) {
-	buffer := new(bytes.Buffer)
-	multipartWriter := multipart.NewWriter(buffer)
-
-	var err error
-	if err = generateCertificateBody(multipartWriter, id, password, files); err != nil {
-		return "", err
+	certificate, err := bundle.PEM()
+	if err != nil {
+		return result, err
 	}
 
-	requestUrl := c.baseUrl.JoinPath("/cgi-bin/firmwarecfg").String()
 	var resp *http.Response
-	if resp, err = c.httpClient.Post(requestUrl, multipartWriter.FormDataContentType(), buffer); err != nil {
-		return "", err
+	if resp, err = c.postFirmwarecfg([]formPart{
+		{Name: "sid", Value: string(id)},
+		{Name: "BoxCertPassword", Value: bundle.Passphrase},
+		{Name: "BoxCertImportFile", FileName: "BoxCert.pem", Content: bytes.NewReader(certificate)},
+	}); err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	if result.Message, err = parseUpdateResponse(resp); err != nil {
+		return result, err
 	}
 
-	var updateMessage string
-	if updateMessage, err = parseUpdateResponse(resp); err != nil {
-		return "", err
+	if options.Verify {
+		if err = c.verifyServedCertificate(&result, options); err != nil {
+			return result, err
+		}
 	}
 
-	return updateMessage, nil
+	return result, nil
 }
 
 func decodeEmbeddedJson(reader io.Reader, v interface{}, prefix string, suffix string) error {
@@ -141,6 +173,9 @@ func decodeEmbeddedJson(reader io.Reader, v interface{}, prefix string, suffix s
 	return errors.New("could not find embedded json")
 }
 
+// ListPhoneNumbers lists the phone numbers of the fon_num_list page. On
+// firmware with the REST API, SIP numbers are completed with the account data
+// of the REST API.
 func (c *FritzboxClient) ListPhoneNumbers(id SessionID) ([]PhoneNumber, error) {
 	requestUrl := c.baseUrl.JoinPath("/fon_num/fon_num_list.lua").String()
 
@@ -158,10 +193,22 @@ func (c *FritzboxClient) ListPhoneNumbers(id SessionID) ([]PhoneNumber, error) {
 	if err = decodeEmbeddedJson(resp.Body, &data, "var gFonNums = ", ";"); err != nil {
 		return data, err
 	}
+	if c.useRest(id) {
+		c.restCompletePhoneNumbers(id, data)
+	}
 	return data, nil
 }
 
+// GetPhoneNumber reads the sip_edit page of the phone number. The data is
+// taken from the embedded JavaScript, unless the detected firmware offers the
+// JSON variant and it decodes.
 func (c *FritzboxClient) GetPhoneNumber(id SessionID, phoneNumberId string) (PhoneNumber, error) {
+	var data PhoneNumber
+	if c.prefers(CapabilitySipEditJSON) {
+		if data, err := c.getPhoneNumberJson(id, phoneNumberId); err == nil {
+			return data, nil
+		}
+	}
 	requestUrl := c.baseUrl.JoinPath("/data.lua").String()
 
 	params := fmt.Sprintf(
@@ -169,12 +216,12 @@ func (c *FritzboxClient) GetPhoneNumber(id SessionID, phoneNumberId string) (Pho
 		phoneNumberId,
 		string(id),
 	)
-	var data PhoneNumber
 	var err error
 	var resp *http.Response
 	if resp, err = c.httpClient.Post(requestUrl, "application/x-www-form-urlencoded", strings.NewReader(params)); err != nil {
 		return data, err
 	}
+	defer resp.Body.Close()
 
 	if err = decodeEmbeddedJson(resp.Body, &data, "const g_fondata = [", "];"); err != nil {
 		return data, err
@@ -182,17 +229,39 @@ func (c *FritzboxClient) GetPhoneNumber(id SessionID, phoneNumberId string) (Pho
 	return data, nil
 }
 
-func (c *FritzboxClient) DisableSIP(id SessionID, sipID string) error {
-	requestUrl := c.baseUrl.JoinPath("/data.lua").String()
+func (c *FritzboxClient) getPhoneNumberJson(id SessionID, phoneNumberId string) (PhoneNumber, error) {
+	resp, err := c.httpClient.PostForm(c.baseUrl.JoinPath("/data.lua").String(), url.Values{
+		"xhr":     {"1"},
+		"uid":     {phoneNumberId},
+		"sid":     {string(id)},
+		"page":    {"sip_edit"},
+		"useajax": {"1"},
+	})
+	if err != nil {
+		return PhoneNumber{}, err
+	}
+	defer resp.Body.Close()
+	return decodeSipEditJson(resp.Body)
+}
 
-	values := url.Values{
-		"xhr":   {"1"},
-		"isnew": {"0"},
-		"uid":   {sipID},
-		"sid":   {string(id)},
-		"page":  {"sip_edit"},
-		"apply": {""},
+// decodeSipEditJson decodes the JSON variant of the sip_edit page.
+func decodeSipEditJson(reader io.Reader) (PhoneNumber, error) {
+	var response struct {
+		Data struct {
+			FonData []PhoneNumber `json:"fondata"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(reader).Decode(&response); err != nil {
+		return PhoneNumber{}, err
 	}
+	if len(response.Data.FonData) == 0 {
+		return PhoneNumber{}, errors.New("could not find phone number data")
+	}
+	return response.Data.FonData[0], nil
+}
+
+func (c *FritzboxClient) applyForm(values url.Values) error {
+	requestUrl := c.baseUrl.JoinPath("/data.lua").String()
 
 	var err error
 	var resp *http.Response
@@ -213,39 +282,196 @@ func (c *FritzboxClient) DisableSIP(id SessionID, sipID string) error {
 	return nil
 }
 
-func (c *FritzboxClient) EnableSIP(id SessionID, sipID string, provider string, areaCode string, localNumber string, username string, password string) error {
+// DisableSIP deactivates the phone number, keeping all of its settings.
+func (c *FritzboxClient) DisableSIP(id SessionID, phoneNumber PhoneNumber) error {
+	phoneNumber.Active = NewFlexBool(false)
+	return c.SaveSIPNumber(id, phoneNumber)
+}
+
+// EnableSIP activates the phone number, keeping all of its settings.
+func (c *FritzboxClient) EnableSIP(id SessionID, phoneNumber PhoneNumber) error {
+	phoneNumber.Active = NewFlexBool(true)
+	return c.SaveSIPNumber(id, phoneNumber)
+}
+
+func (c *FritzboxClient) ListSIPProviders(id SessionID) ([]SipProvider, error) {
 	requestUrl := c.baseUrl.JoinPath("/data.lua").String()
 
 	values := url.Values{
-		"xhr":            {"1"},
-		"isnew":          {"0"},
-		"sipactive":      {"on"},
-		"sipprovider":    {provider},
-		"numberinput1_1": {areaCode},
-		"numberinput2_1": {localNumber},
-		"username":       {username},
-		"password":       {password},
-		"uid":            {sipID},
-		"sid":            {string(id)},
-		"page":           {"sip_edit"},
-		"apply":          {""},
+		"xhr":   {"1"},
+		"isnew": {"1"},
+		"sid":   {string(id)},
+		"page":  {"sip_edit"},
 	}
 
+	var data []SipProvider
 	var err error
 	var resp *http.Response
 	if resp, err = c.httpClient.PostForm(requestUrl, values); err != nil {
-		return err
+		return data, err
 	}
+	if err = decodeEmbeddedJson(resp.Body, &data, "const g_providerlist = ", ";"); err != nil {
+		return data, err
+	}
+	return data, nil
+}
 
-	var updateResult UpdateResult
-	if err = json.NewDecoder(resp.Body).Decode(&updateResult); err != nil {
-		return err
+func (c *FritzboxClient) AddSIPNumber(id SessionID, provider string, areaCode string, localNumber string, username string, password string) error {
+	return c.SaveSIPNumber(id, PhoneNumber{
+		Active:      NewFlexBool(true),
+		ProviderId:  provider,
+		AreaCode:    areaCode,
+		LocalNumber: localNumber,
+		Sip: SipData{
+			Username: username,
+			Password: password,
+		},
+	})
+}
+
+// sipEditForm returns the sip_edit form as the page posts it. The box resets
+// settings missing from the form, so every setting of the number including
+// its dialing rules (telcfg) is sent: text fields as they are, enumerations by
+// their code and checkboxes only if they are checked.
+func sipEditForm(phoneNumber PhoneNumber) url.Values {
+	sip, telcfg := phoneNumber.Sip, phoneNumber.TelConfig
+	values := url.Values{
+		"sipprovider":         {phoneNumber.ProviderId},
+		"numberinput1_1":      {phoneNumber.AreaCode},
+		"numberinput2_1":      {phoneNumber.LocalNumber},
+		"username":            {sip.Username},
+		"password":            {sip.Password},
+		"authname":            {sip.Authname},
+		"displayname":         {sip.DisplayName},
+		"registrar":           {sip.Registrar},
+		"outboundproxy":       {sip.OutboundProxy},
+		"stunserver":          {sip.StunServer},
+		"protocolprefer":      {sip.ProtocolPrefer},
+		"Trunk":               {sip.Trunk},
+		"Reception":           {sip.Reception},
+		"ExtensionLength":     {sip.ExtensionLength},
+		"transport_type":      {transportTypeNames.value(sip.TransportType.enumValue)},
+		"clirtype":            {clirTypeNames.value(sip.ClirType.enumValue)},
+		"crypto_avp_mode":     {srtpModeNames.value(sip.CryptoAvpMode.enumValue)},
+		"dtmfcfg":             {dtmfModeNames.value(sip.DTMFConfig.enumValue)},
+		"tx_packetsize_in_ms": {sip.TxPacketSizeInMs},
+		"sipping_interval":    {sip.SippingInterval},
+		"clipnstype":          {sip.ClipNsType},
+		"dditype":             {sip.DdiType},
+		"mode":                {sip.Mode},
+		"RegistryType":        {telcfg.RegistryType},
+		"AKN":                 {telcfg.AKN},
+		"EmergencyRule":       {telcfg.EmergencyRule},
+		"Suffix":              {telcfg.Suffix},
+		"AlternatePrefix":     {telcfg.AlternatePrefix},
+	}
+	checkboxes := map[string]FlexBool{
+		"sipactive":                          phoneNumber.Active,
+		"outboundproxy_without_route_header": sip.OutboundProxyWithoutRouteHeader,
+		"mwi_supported":                      sip.MwiSupported,
+		"use_internat_calling_numb":          sip.UseInternatCallingNumber,
+		"do_not_register":                    sip.DoNotRegister,
+		"g726_via_rfc3551_":                  sip.G726ViaRfc3551,
+		"call_deflection":                    sip.CallDeflection,
+		"encryption_enabled":                 sip.EncryptionEnabled,
+		"srtp_supported":                     sip.SrtpSupported,
+		"no_register_fetch":                  sip.NoRegisterFetch,
+		"ccbs_supported":                     sip.CcbsSupported,
+		"read_p_asserted_identity_header":    sip.ReadPAssertedIdentityHeader,
+		"route_always_over_internet":         sip.RouteAlwaysOverInternet,
+		"voip_over_mobile":                   sip.VoipOverMobile,
+		"authname_needed":                    sip.AuthnameNeeded,
+		"KeepLKZPrefix":                      telcfg.KeepLKZPrefix,
+		"KeepOKZPrefix":                      telcfg.KeepOKZPrefix,
+		"ClipNoScreening":                    telcfg.ClipNoScreening,
+		"UseOKZ":                             telcfg.UseOKZ,
+		"UseLKZ":                             telcfg.UseLKZ,
+	}
+	for name, checked := range checkboxes {
+		if checked.Bool() {
+			values.Set(name, "on")
+		}
 	}
-	if updateResult.Data.Apply == "valerror" {
-		return errors.New(updateResult.Data.ValError.Alert)
+	return values
+}
+
+// SIPSettings returns the settings of the phone number that SaveSIPNumber
+// writes, keyed by their sip_edit form field. Unchecked checkboxes are
+// missing.
+func SIPSettings(phoneNumber PhoneNumber) url.Values {
+	return sipEditForm(phoneNumber)
+}
+
+// SaveSIPNumber writes the settings of phoneNumber to the box through the
+// sip_edit form. A phone number without Uid is created as a new number.
+func (c *FritzboxClient) SaveSIPNumber(id SessionID, phoneNumber PhoneNumber) error {
+	values := sipEditForm(phoneNumber)
+	values.Set("xhr", "1")
+	values.Set("sid", string(id))
+	values.Set("page", "sip_edit")
+	values.Set("apply", "")
+	if phoneNumber.Uid == "" {
+		values.Set("isnew", "1")
+	} else {
+		values.Set("isnew", "0")
+		values.Set("uid", phoneNumber.Uid)
+	}
+	return c.applyForm(values)
+}
+
+func (c *FritzboxClient) DeleteSIPNumber(id SessionID, sipID string) error {
+	phoneNumber, err := c.GetPhoneNumber(id, sipID)
+	if err != nil {
+		return err
 	}
-	if updateResult.Data.Apply != "ok" {
-		return errors.New("unknown error while processing response")
+	return c.DeletePhoneNumber(id, phoneNumber)
+}
+
+// DeletePhoneNumber deletes a phone number of any type, as long as the box
+// marks it as deletable.
+func (c *FritzboxClient) DeletePhoneNumber(id SessionID, phoneNumber PhoneNumber) error {
+	if !phoneNumber.Deletable.Bool() {
+		return fmt.Errorf("%w: %s", ErrNotDeletable, phoneNumber.Number)
+	}
+	return c.applyForm(url.Values{
+		"xhr":    {"1"},
+		"delete": {phoneNumber.Uid},
+		"sid":    {string(id)},
+		"page":   {"fon_num_list"},
+		"apply":  {""},
+	})
+}
+
+// WaitSIPRegistered polls the list of phone numbers until the number with the
+// given uid reports a successful registration or the timeout expires. If the
+// number was registered before its settings were saved, that registration
+// may still be reported, so a registration only counts before the deadline
+// once the number dropped it. The box reports no registration time, and it
+// often re-registers between two polls, so a number that is registered at the
+// deadline counts as registered as well. ErrNotRegistered is returned if the
+// number is not registered at the deadline.
+func (c *FritzboxClient) WaitSIPRegistered(id SessionID, sipID string, wasRegistered bool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		phoneNumbers, err := c.ListPhoneNumbers(id)
+		if err != nil {
+			return err
+		}
+		index := slices.IndexFunc(phoneNumbers, func(phoneNumber PhoneNumber) bool { return phoneNumber.Uid == sipID })
+		if index < 0 {
+			return fmt.Errorf("phone number %s not found", sipID)
+		}
+		registered := phoneNumbers[index].Registered.Bool()
+		expired := time.Now().After(deadline)
+		switch {
+		case !registered:
+			wasRegistered = false
+		case !wasRegistered || expired:
+			return nil
+		}
+		if expired {
+			return fmt.Errorf("%w: phone number %s did not register within %s", ErrNotRegistered, sipID, timeout)
+		}
+		time.Sleep(time.Second)
 	}
-	return nil
 }
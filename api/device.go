@@ -0,0 +1,187 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var ErrDeviceNotFound = errors.New("network device not found")
+
+// AccessProfile is a parental control and access profile of the box.
+type AccessProfile struct {
+	Id   string
+	Name string
+}
+
+// LanDeviceSettings are the settings of a network device on the edit_device
+// page.
+type LanDeviceSettings struct {
+	UID  string
+	Name string
+	IPv4 string
+	// StaticIP makes the box always assign the same IPv4 address to the
+	// device.
+	StaticIP bool
+	// Profile is the id of the access profile.
+	Profile  string
+	Profiles []AccessProfile
+
+	// The remaining fields of the edit_device form are not managed by this
+	// client but have to be posted again, or the box resets them.
+	interfaceID      string
+	allowPortSharing FlexBool
+	realtimePriority FlexBool
+}
+
+// ProfileByName returns the access profile with the given name or id.
+func (s LanDeviceSettings) ProfileByName(name string) (AccessProfile, bool) {
+	for _, profile := range s.Profiles {
+		if strings.EqualFold(profile.Name, name) || profile.Id == name {
+			return profile, true
+		}
+	}
+	return AccessProfile{}, false
+}
+
+// ProfileName returns the name of the assigned access profile.
+func (s LanDeviceSettings) ProfileName() string {
+	if profile, ok := s.ProfileByName(s.Profile); ok {
+		return profile.Name
+	}
+	return s.Profile
+}
+
+type editDeviceResponse struct {
+	Data struct {
+		Vars struct {
+			Dev struct {
+				UID  string `json:"UID"`
+				Name struct {
+					DisplayName string `json:"displayName"`
+				} `json:"name"`
+				IPv4 struct {
+					Current struct {
+						IP string `json:"ip"`
+					} `json:"current"`
+					StaticDhcp FlexBool `json:"staticDhcp"`
+				} `json:"ipv4"`
+				IPv6 struct {
+					InterfaceID string `json:"iid"`
+				} `json:"ipv6"`
+				PortForwarding struct {
+					AllowForwarding FlexBool `json:"allowForwarding"`
+				} `json:"portForwarding"`
+				RealtimePriority FlexBool `json:"realtimeprio"`
+				NetAccess        struct {
+					Kisi struct {
+						SelectedProfile string `json:"selectedProfile"`
+						Profiles        []struct {
+							Id   string `json:"id"`
+							Name string `json:"name"`
+						} `json:"profiles"`
+					} `json:"kisi"`
+				} `json:"netAccess"`
+			} `json:"dev"`
+		} `json:"vars"`
+	} `json:"data"`
+}
+
+// GetLanDeviceSettings reads the edit_device page of the device with the uid.
+func (c *FritzboxClient) GetLanDeviceSettings(id SessionID, uid string) (LanDeviceSettings, error) {
+	var settings LanDeviceSettings
+	resp, err := c.httpClient.PostForm(c.baseUrl.JoinPath("/data.lua").String(), url.Values{
+		"xhr":  {"1"},
+		"sid":  {string(id)},
+		"page": {"edit_device"},
+		"dev":  {uid},
+	})
+	if err != nil {
+		return settings, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return settings, fmt.Errorf("unexpected status while retrieving device %s: %s", uid, resp.Status)
+	}
+	var response editDeviceResponse
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return settings, err
+	}
+	dev := response.Data.Vars.Dev
+	if dev.UID == "" {
+		return settings, fmt.Errorf("%w: %s", ErrDeviceNotFound, uid)
+	}
+	settings = LanDeviceSettings{
+		UID:      dev.UID,
+		Name:     dev.Name.DisplayName,
+		IPv4:     dev.IPv4.Current.IP,
+		StaticIP: dev.IPv4.StaticDhcp.Bool(),
+		Profile:  dev.NetAccess.Kisi.SelectedProfile,
+
+		interfaceID:      dev.IPv6.InterfaceID,
+		allowPortSharing: dev.PortForwarding.AllowForwarding,
+		realtimePriority: dev.RealtimePriority,
+	}
+	for _, profile := range dev.NetAccess.Kisi.Profiles {
+		settings.Profiles = append(settings.Profiles, AccessProfile{Id: profile.Id, Name: profile.Name})
+	}
+	return settings, nil
+}
+
+// interfaceIDGroups splits the IPv6 interface id into the four groups of the
+// interface_id fields. An id that is not an IPv6 address yields no groups.
+func interfaceIDGroups(interfaceID string) []string {
+	address, err := netip.ParseAddr(interfaceID)
+	if err != nil || !address.Is6() {
+		return nil
+	}
+	bytes := address.As16()
+	groups := make([]string, 4)
+	for i := range groups {
+		groups[i] = strconv.FormatUint(uint64(bytes[8+2*i])<<8|uint64(bytes[9+2*i]), 16)
+	}
+	return groups
+}
+
+// editDeviceForm returns the complete edit_device form for the settings, as
+// the web interface posts it.
+func editDeviceForm(settings LanDeviceSettings) url.Values {
+	values := url.Values{
+		"dev":          {settings.UID},
+		"dev_name":     {settings.Name},
+		"dev_ip":       {settings.IPv4},
+		"kisi_profile": {settings.Profile},
+		"back_to_page": {"netDev"},
+	}
+	for i, group := range interfaceIDGroups(settings.interfaceID) {
+		values.Set(fmt.Sprintf("interface_id%d", i+1), group)
+	}
+	checkboxes := map[string]bool{
+		"static_dhcp":        settings.StaticIP,
+		"allow_pcp_and_upnp": settings.allowPortSharing.Bool(),
+		"realtimeprio":       settings.realtimePriority.Bool(),
+	}
+	for name, checked := range checkboxes {
+		if checked {
+			values.Set(name, "on")
+		}
+	}
+	return values
+}
+
+// SaveLanDeviceSettings writes the settings to the box through the
+// edit_device form. Settings read by GetLanDeviceSettings keep the form
+// fields this client does not manage.
+func (c *FritzboxClient) SaveLanDeviceSettings(id SessionID, settings LanDeviceSettings) error {
+	values := editDeviceForm(settings)
+	values.Set("xhr", "1")
+	values.Set("sid", string(id))
+	values.Set("page", "edit_device")
+	values.Set("apply", "")
+	return c.applyForm(values)
+}
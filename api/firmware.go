@@ -0,0 +1,180 @@
+package api
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"time"
+)
+
+var ErrInvalidFirmwareImage = errors.New("not a FRITZ!OS firmware image")
+
+const (
+	userInterfaceService = "urn:dslforum-org:service:UserInterface:1"
+	userInterfacePath    = "/upnp/control/userif"
+
+	// firmwareFlashTime is how long the box may take to write a firmware
+	// image before it restarts.
+	firmwareFlashTime = 5 * time.Minute
+)
+
+// FirmwareImage describes a firmware image file, a tar archive with the
+// install script and the kernel and file system images.
+type FirmwareImage struct {
+	Files []string
+	// HWRevisions are the hardware revisions the install script accepts.
+	HWRevisions []string
+}
+
+// Supports reports whether the image can be installed on a box with the
+// hardware revision. Images without recognizable check are refused, as it is
+// unknown which boxes they are for.
+func (i FirmwareImage) Supports(hwRevision string) bool {
+	return slices.Contains(i.HWRevisions, hwRevision)
+}
+
+var hwRevisionPattern = regexp.MustCompile(`HWRevision"?\s*(?:=|==|-eq)\s*"?(\d+)`)
+
+// ReadFirmwareImage checks that the content is a firmware image and extracts
+// the hardware revisions its install script accepts.
+func ReadFirmwareImage(content io.Reader) (FirmwareImage, error) {
+	var image FirmwareImage
+	var install bool
+	archive := tar.NewReader(content)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return image, fmt.Errorf("%w: %w", ErrInvalidFirmwareImage, err)
+		}
+		name := path.Clean("/" + header.Name)
+		image.Files = append(image.Files, name)
+		if name != "/var/install" {
+			continue
+		}
+		install = true
+		script, err := io.ReadAll(io.LimitReader(archive, 1<<20))
+		if err != nil {
+			return image, fmt.Errorf("%w: %w", ErrInvalidFirmwareImage, err)
+		}
+		for _, match := range hwRevisionPattern.FindAllStringSubmatch(string(script), -1) {
+			if !slices.Contains(image.HWRevisions, match[1]) {
+				image.HWRevisions = append(image.HWRevisions, match[1])
+			}
+		}
+	}
+	if !install {
+		return image, fmt.Errorf("%w: missing install script", ErrInvalidFirmwareImage)
+	}
+	return image, nil
+}
+
+// FirmwareUpdateOptions control firmware updates. With Wait set, the update
+// waits until the box is reachable again after installing the firmware.
+type FirmwareUpdateOptions struct {
+	Wait    bool
+	Timeout time.Duration
+}
+
+type FirmwareUpdateResult struct {
+	Message    string
+	OldVersion string
+	NewVersion string
+	Ready      ReadyReport
+}
+
+// FirmwareUpdateInfo is the result of the online update check.
+type FirmwareUpdateInfo struct {
+	Available   bool
+	Version     string
+	InfoURL     string
+	DownloadURL string
+	State       string
+}
+
+// finishFirmwareUpdate waits for the box to install the firmware and restart
+// and records the versions before and after.
+func (c *FritzboxClient) finishFirmwareUpdate(result *FirmwareUpdateResult, options FirmwareUpdateOptions) error {
+	if !options.Wait {
+		return nil
+	}
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Minute
+	}
+	// Flashing takes a while before the box restarts, but the box has to
+	// come back within the same timeout.
+	var err error
+	if result.Ready, err = c.WaitReady(ReadyOptions{DownWithin: min(firmwareFlashTime, timeout/2), Timeout: timeout}); err != nil {
+		return err
+	}
+	if info, err := c.GetBoxInfo(); err == nil {
+		result.NewVersion = info.Version
+	}
+	return nil
+}
+
+// UploadFirmware installs a firmware image. The content is streamed to the
+// box and closed after the upload if it is an io.Closer.
+func (c *FritzboxClient) UploadFirmware(id SessionID, content io.Reader, options FirmwareUpdateOptions) (FirmwareUpdateResult, error) {
+	var result FirmwareUpdateResult
+	if info, err := c.GetBoxInfo(); err == nil {
+		result.OldVersion = info.Version
+	}
+
+	resp, err := c.postFirmwarecfg([]formPart{
+		{Name: "sid", Value: string(id)},
+		{Name: "UploadFile", FileName: "fritz.image", Content: content},
+	})
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return result, fmt.Errorf("unexpected status while uploading firmware: %s", resp.Status)
+	}
+	if _, result.Message, err = parseUploadMessage(resp); err != nil {
+		return result, err
+	}
+
+	return result, c.finishFirmwareUpdate(&result, options)
+}
+
+// CheckFirmwareUpdate asks the box to check for a new firmware version online.
+func (c *FritzboxClient) CheckFirmwareUpdate() (FirmwareUpdateInfo, error) {
+	var info FirmwareUpdateInfo
+	if _, err := c.tr064Call(userInterfaceService, userInterfacePath, "X_AVM-DE_CheckUpdate", map[string]string{"NewX_AVM-DE_LaborVersion": ""}); err != nil {
+		return info, err
+	}
+	result, err := c.tr064Call(userInterfaceService, userInterfacePath, "GetInfo", nil)
+	if err != nil {
+		return info, err
+	}
+	info.Available = result["NewUpgradeAvailable"] == "1"
+	info.Version = result["NewX_AVM-DE_Version"]
+	info.InfoURL = result["NewX_AVM-DE_InfoURL"]
+	info.DownloadURL = result["NewX_AVM-DE_DownloadURL"]
+	info.State = result["NewX_AVM-DE_UpdateState"]
+	return info, nil
+}
+
+// UpdateFirmware triggers the online update to the latest firmware version.
+func (c *FritzboxClient) UpdateFirmware(options FirmwareUpdateOptions) (FirmwareUpdateResult, error) {
+	var result FirmwareUpdateResult
+	if info, err := c.GetBoxInfo(); err == nil {
+		result.OldVersion = info.Version
+	}
+	response, err := c.tr064Call(userInterfaceService, userInterfacePath, "X_AVM-DE_DoUpdate", nil)
+	if err != nil {
+		return result, err
+	}
+	result.Message = response["NewX_AVM-DE_UpdateState"]
+	return result, c.finishFirmwareUpdate(&result, options)
+}
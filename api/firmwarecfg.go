@@ -0,0 +1,147 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+)
+
+// formPart is a field of a multipart form posted to firmwarecfg. Parts with
+// a FileName are sent as file with the data read from Content.
+type formPart struct {
+	Name     string
+	Value    string
+	FileName string
+	Content  io.Reader
+}
+
+func closeFormParts(parts []formPart) {
+	for _, part := range parts {
+		if closer, ok := part.Content.(io.Closer); ok {
+			_ = closer.Close()
+		}
+	}
+}
+
+func writeFormParts(multipartWriter *multipart.Writer, parts []formPart) error {
+	for _, part := range parts {
+		if part.FileName == "" {
+			if err := multipartWriter.WriteField(part.Name, part.Value); err != nil {
+				return err
+			}
+			continue
+		}
+		fileWriter, err := multipartWriter.CreateFormFile(part.Name, part.FileName)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(fileWriter, part.Content); err != nil {
+			return err
+		}
+	}
+	return multipartWriter.Close()
+}
+
+// contentLength returns the number of bytes left in the content. Contents
+// whose length is unknown are read into memory and closed, the returned
+// reader replaces the content then.
+func contentLength(content io.Reader) (int64, io.Reader, error) {
+	switch sized := content.(type) {
+	case interface{ Len() int }:
+		return int64(sized.Len()), content, nil
+	case *os.File:
+		info, err := sized.Stat()
+		if err != nil {
+			return 0, content, err
+		}
+		if info.Mode().IsRegular() {
+			offset, err := sized.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return 0, content, err
+			}
+			return info.Size() - offset, content, nil
+		}
+	}
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return 0, content, err
+	}
+	closeFormParts([]formPart{{Content: content}})
+	return int64(len(data)), bytes.NewReader(data), nil
+}
+
+// formLength returns the size of the encoded form. The form is encoded with
+// the boundary of the multipart writer but without file contents, whose
+// lengths are added instead.
+// Contents read into memory are replaced in parts.
+func formLength(boundary string, parts []formPart) (int64, error) {
+	var counter countingWriter
+	multipartWriter := multipart.NewWriter(&counter)
+	if err := multipartWriter.SetBoundary(boundary); err != nil {
+		return 0, err
+	}
+	var length int64
+	measured := make([]formPart, len(parts))
+	for i, part := range parts {
+		measured[i] = part
+		if part.FileName == "" {
+			continue
+		}
+		size, content, err := contentLength(part.Content)
+		parts[i].Content = content
+		if err != nil {
+			return 0, err
+		}
+		length += size
+		measured[i].Content = bytes.NewReader(nil)
+	}
+	if err := writeFormParts(multipartWriter, measured); err != nil {
+		return 0, err
+	}
+	return length + counter.n, nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// postFirmwarecfg posts the parts to /cgi-bin/firmwarecfg, which handles
+// certificate, configuration and firmware uploads and downloads. The form is
+// encoded while it is sent, so large files are never held in memory, but with
+// its length computed up front, as the box does not accept chunked uploads.
+// Contents implementing io.Closer are closed once the request is done.
+func (c *FritzboxClient) postFirmwarecfg(parts []formPart) (*http.Response, error) {
+	pipeReader, pipeWriter := io.Pipe()
+	multipartWriter := multipart.NewWriter(pipeWriter)
+	length, err := formLength(multipartWriter.Boundary(), parts)
+	if err != nil {
+		closeFormParts(parts)
+		return nil, err
+	}
+	go func() {
+		defer closeFormParts(parts)
+		_ = pipeWriter.CloseWithError(writeFormParts(multipartWriter, parts))
+	}()
+
+	request, err := http.NewRequest(http.MethodPost, c.baseUrl.JoinPath("/cgi-bin/firmwarecfg").String(), pipeReader)
+	if err != nil {
+		_ = pipeReader.CloseWithError(err)
+		return nil, err
+	}
+	request.Header.Set("Content-Type", multipartWriter.FormDataContentType())
+	request.ContentLength = length
+	resp, err := c.httpClient.Do(request)
+	if err != nil {
+		// Unblock the encoder if the request failed before reading the body.
+		_ = pipeReader.CloseWithError(err)
+		return nil, err
+	}
+	return resp, nil
+}
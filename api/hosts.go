@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	hostsService = "urn:dslforum-org:service:Hosts:1"
+	hostsPath    = "/upnp/control/hosts"
+)
+
+// tr064HostList is the host list file of the TR-064 Hosts service.
+type tr064HostList struct {
+	Items []struct {
+		IPAddress     string `xml:"IPAddress"`
+		MACAddress    string `xml:"MACAddress"`
+		Active        string `xml:"Active"`
+		HostName      string `xml:"HostName"`
+		InterfaceType string `xml:"InterfaceType"`
+		Speed         string `xml:"X_AVM-DE_Speed"`
+	} `xml:"Item"`
+}
+
+// tr064HostList downloads the host list, whose path TR-064 returns including
+// a session id, from the TR-064 port.
+func (c *FritzboxClient) tr064HostList() ([]LanDevice, error) {
+	result, err := c.tr064Call(hostsService, hostsPath, "X_AVM-DE_GetHostListPath", nil)
+	if err != nil {
+		return nil, err
+	}
+	listPath, err := url.Parse(result["NewX_AVM-DE_HostListPath"])
+	if err != nil {
+		return nil, err
+	}
+	listUrl, err := url.Parse(c.tr064Url(listPath.Path))
+	if err != nil {
+		return nil, err
+	}
+	listUrl.RawQuery = listPath.RawQuery
+
+	resp, err := c.httpClient.Get(listUrl.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status while retrieving host list: %s", resp.Status)
+	}
+	var list tr064HostList
+	if err = xml.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	devices := make([]LanDevice, 0, len(list.Items))
+	for _, item := range list.Items {
+		device := LanDevice{
+			Name:      item.HostName,
+			MAC:       item.MACAddress,
+			IPv4:      item.IPAddress,
+			Interface: strings.ToLower(item.InterfaceType),
+			Active:    item.Active == "1",
+		}
+		if device.Interface == "802.11" {
+			device.Interface = "wlan"
+		}
+		device.Speed, _ = strconv.Atoi(item.Speed)
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// ListHosts lists every device in the home network known to the box. It
+// prefers the TR-064 host list, completed with the IPv6 addresses and last
+// seen times of ListLanDevices, and falls back to ListLanDevices if TR-064 is
+// not available. If the list is incomplete for either reason, warning
+// describes what is missing.
+func (c *FritzboxClient) ListHosts(id SessionID) (hosts []LanDevice, warning error, err error) {
+	hosts, err = c.tr064HostList()
+	if err != nil {
+		warning = fmt.Errorf("TR-064 host list not available, falling back to the web interface: %w", err)
+		if hosts, err = c.ListLanDevices(id); err != nil {
+			return nil, warning, err
+		}
+		return hosts, warning, nil
+	}
+
+	lanDevices, err := c.ListLanDevices(id)
+	if err != nil {
+		return hosts, fmt.Errorf("IPv6 addresses and last seen times not available: %w", err), nil
+	}
+	byMAC := make(map[string]LanDevice, len(lanDevices))
+	for _, device := range lanDevices {
+		byMAC[strings.ToUpper(device.MAC)] = device
+	}
+	for i, host := range hosts {
+		device, ok := byMAC[strings.ToUpper(host.MAC)]
+		if !ok {
+			continue
+		}
+		hosts[i].UID = device.UID
+		hosts[i].IPv6 = device.IPv6
+		hosts[i].LastSeen = device.LastSeen
+		if hosts[i].Interface == "" {
+			hosts[i].Interface = device.Interface
+		}
+	}
+	return hosts, nil, nil
+}
+
+// WakeOnLAN makes the box send a magic packet to the device with the MAC
+// address.
+func (c *FritzboxClient) WakeOnLAN(mac string) error {
+	_, err := c.tr064Call(hostsService, hostsPath, "X_AVM-DE_WakeOnLANByMACAddress", map[string]string{"NewMACAddress": mac})
+	return err
+}
+
+// HostActive reports whether the device with the MAC address is connected.
+func (c *FritzboxClient) HostActive(mac string) (bool, error) {
+	result, err := c.tr064Call(hostsService, hostsPath, "GetSpecificHostEntry", map[string]string{"NewMACAddress": mac})
+	if err != nil {
+		return false, err
+	}
+	return result["NewActive"] == "1", nil
+}
+
+// WaitHostActive polls the host entry until the device with the MAC address
+// is connected or the timeout expires. If the device was reported active
+// before it was woken, the box may still hold its stale entry, so the device
+// has to show as inactive first.
+func (c *FritzboxClient) WaitHostActive(mac string, wasActive bool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		active, err := c.HostActive(mac)
+		if err != nil {
+			return err
+		}
+		if !active {
+			wasActive = false
+		} else if !wasActive {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if wasActive {
+				return fmt.Errorf("device %s was already active before the wake-up and stayed active for %s, the wake-up is not confirmed", mac, timeout)
+			}
+			return fmt.Errorf("device %s did not become active within %s", mac, timeout)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
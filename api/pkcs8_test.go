@@ -0,0 +1,72 @@
+package api
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The keys in testdata are the key of certificate.pem, encrypted as PKCS#8
+// with the password "secret".
+func loadTestBundle(t *testing.T, keyFile string, password string) (CertificateBundle, error) {
+	t.Helper()
+	var inputs [][]byte
+	for _, name := range []string{"certificate.pem", keyFile} {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, data)
+	}
+	return LoadCertificateBundle(inputs, password)
+}
+
+func TestEncryptedPKCS8Key(t *testing.T) {
+	for _, keyFile := range []string{"key-pbes2-aes.pem", "key-pbes2-des3.pem"} {
+		bundle, err := loadTestBundle(t, keyFile, "secret")
+		if err != nil {
+			t.Errorf("%s: %v", keyFile, err)
+			continue
+		}
+		if bundle.Key == nil || !keyMatches(bundle.Leaf(), bundle.Key) {
+			t.Errorf("%s: key was not decrypted", keyFile)
+		}
+
+		// The box only reads traditional keys with legacy PEM encryption.
+		data, err := bundle.KeyPEM()
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "EC PRIVATE KEY" || !x509.IsEncryptedPEMBlock(block) {
+			t.Fatalf("%s: unexpected key PEM:\n%s", keyFile, data)
+		}
+		der, err := x509.DecryptPEMBlock(block, []byte("secret"))
+		if err != nil {
+			t.Fatalf("%s: %v", keyFile, err)
+		}
+		if key, err := x509.ParseECPrivateKey(der); err != nil || !keyMatches(bundle.Leaf(), key) {
+			t.Errorf("%s: re-encoded key does not match: %v", keyFile, err)
+		}
+	}
+}
+
+func TestEncryptedPKCS8KeyErrors(t *testing.T) {
+	tests := []struct {
+		keyFile  string
+		password string
+		want     error
+	}{
+		{"key-pbes2-aes.pem", "", ErrKeyPasswordRequired},
+		{"key-pbes2-aes.pem", "wrong", x509.IncorrectPasswordError},
+		{"key-pbes1.pem", "secret", ErrUnsupportedKeyEncryption},
+	}
+	for _, test := range tests {
+		if _, err := loadTestBundle(t, test.keyFile, test.password); !errors.Is(err, test.want) {
+			t.Errorf("%s with password %q: expected %v, got %v", test.keyFile, test.password, test.want, err)
+		}
+	}
+}
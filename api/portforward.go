@@ -0,0 +1,283 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+	// FamilyBoth is the ip_version of openings on the port sharing page that
+	// also forward IPv4.
+	FamilyBoth = "both"
+)
+
+// PortForward is an IPv4 port forwarding rule or an IPv6 firewall opening for
+// a device.
+type PortForward struct {
+	Family   string
+	Protocol string
+	// Port is the external port, or the first port of the opened range for
+	// IPv6.
+	Port    int
+	EndPort int
+	// InternalPort and InternalClient are the target of IPv4 forwardings.
+	InternalPort   int
+	InternalClient string
+	// Device is the uid of the device of IPv6 openings.
+	Device     string
+	DeviceName string
+	// DualStack marks IPv6 openings that also forward IPv4 to the device.
+	DualStack   bool
+	Description string
+	Enabled     bool
+	// RemoteHost restricts IPv4 forwardings to a remote address.
+	RemoteHost string
+	// Id identifies IPv6 openings on the web interface.
+	Id string
+}
+
+// Key identifies the rule on the box: the family, protocol, and external
+// port, for IPv4 forwardings also the remote host and for IPv6 openings also
+// the device.
+func (f PortForward) Key() string {
+	key := fmt.Sprintf("%s/%s/%d", f.Family, strings.ToUpper(f.Protocol), f.Port)
+	if f.Family == FamilyIPv6 {
+		key += "/" + f.Device
+	} else if f.RemoteHost != "" {
+		key += "@" + f.RemoteHost
+	}
+	return key
+}
+
+// listIPv4PortForwards enumerates the port mappings of the TR-064
+// WANIPConnection service.
+func (c *FritzboxClient) listIPv4PortForwards() ([]PortForward, error) {
+	result, err := c.tr064Call(wanIPService, wanIPPath, "GetPortMappingNumberOfEntries", nil)
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(result["NewPortMappingNumberOfEntries"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid number of port mappings %q", result["NewPortMappingNumberOfEntries"])
+	}
+	forwards := make([]PortForward, 0, count)
+	for index := 0; index < count; index++ {
+		entry, err := c.tr064Call(wanIPService, wanIPPath, "GetGenericPortMappingEntry", map[string]string{"NewPortMappingIndex": strconv.Itoa(index)})
+		if err != nil {
+			return nil, err
+		}
+		forward := PortForward{
+			Family:         FamilyIPv4,
+			Protocol:       strings.ToUpper(entry["NewProtocol"]),
+			InternalClient: entry["NewInternalClient"],
+			Description:    entry["NewPortMappingDescription"],
+			Enabled:        entry["NewEnabled"] == "1",
+			RemoteHost:     entry["NewRemoteHost"],
+		}
+		forward.Port, _ = strconv.Atoi(entry["NewExternalPort"])
+		forward.InternalPort, _ = strconv.Atoi(entry["NewInternalPort"])
+		forwards = append(forwards, forward)
+	}
+	return forwards, nil
+}
+
+// shareDoResponse is the port sharing overview of the web interface.
+type shareDoResponse struct {
+	Data struct {
+		Devices []struct {
+			UID   string `json:"UID"`
+			Name  string `json:"name"`
+			Rules []struct {
+				Id        string   `json:"id"`
+				Name      string   `json:"name"`
+				Protocol  string   `json:"protocol"`
+				Port      string   `json:"port"`
+				EndPort   string   `json:"endPort"`
+				IPVersion string   `json:"ipVersion"`
+				Active    FlexBool `json:"active"`
+			} `json:"rules"`
+		} `json:"devices"`
+	} `json:"data"`
+}
+
+// listIPv6PortForwards reads the IPv6 firewall openings from the port sharing
+// page, where they are configured per device together with MyFRITZ sharing.
+// Openings for both address families are returned once, with DualStack set.
+func (c *FritzboxClient) listIPv6PortForwards(id SessionID) ([]PortForward, error) {
+	resp, err := c.httpClient.PostForm(c.baseUrl.JoinPath("/data.lua").String(), url.Values{
+		"xhr":  {"1"},
+		"sid":  {string(id)},
+		"page": {"shareDo"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status while retrieving port sharing: %s", resp.Status)
+	}
+	var response shareDoResponse
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	var forwards []PortForward
+	for _, device := range response.Data.Devices {
+		for _, rule := range device.Rules {
+			if rule.IPVersion != FamilyIPv6 && rule.IPVersion != FamilyBoth {
+				continue
+			}
+			forward := PortForward{
+				Family:      FamilyIPv6,
+				Protocol:    strings.ToUpper(rule.Protocol),
+				Device:      device.UID,
+				DeviceName:  device.Name,
+				DualStack:   rule.IPVersion == FamilyBoth,
+				Description: rule.Name,
+				Enabled:     rule.Active.Bool(),
+				Id:          rule.Id,
+			}
+			forward.Port, _ = strconv.Atoi(rule.Port)
+			forward.EndPort, _ = strconv.Atoi(rule.EndPort)
+			forwards = append(forwards, forward)
+		}
+	}
+	return forwards, nil
+}
+
+// ListPortForwards lists the IPv4 port forwardings through TR-064 and the IPv6
+// firewall openings through the web interface. The IPv4 half of a dual stack
+// opening is not listed separately, as it is managed together with the
+// opening. If only one of the sources is available, the rules of the other
+// are missing and warning describes which; dual stack openings are then not
+// recognized either.
+func (c *FritzboxClient) ListPortForwards(id SessionID) (forwards []PortForward, warning error, err error) {
+	ipv4, ipv4Err := c.listIPv4PortForwards()
+	ipv6, ipv6Err := c.listIPv6PortForwards(id)
+	switch {
+	case ipv4Err != nil && ipv6Err != nil:
+		return nil, nil, fmt.Errorf("%w, IPv6 openings not available: %w", ipv4Err, ipv6Err)
+	case ipv4Err != nil:
+		return ipv6, fmt.Errorf("IPv4 port forwardings not available: %w", ipv4Err), nil
+	case ipv6Err != nil:
+		return ipv4, fmt.Errorf("IPv6 openings not available: %w", ipv6Err), nil
+	}
+	dualStack := make(map[string]bool)
+	for _, forward := range ipv6 {
+		if forward.DualStack {
+			dualStack[fmt.Sprintf("%s/%d", forward.Protocol, forward.Port)] = true
+		}
+	}
+	forwards = make([]PortForward, 0, len(ipv4)+len(ipv6))
+	for _, forward := range ipv4 {
+		if forward.RemoteHost == "" && dualStack[fmt.Sprintf("%s/%d", forward.Protocol, forward.Port)] {
+			continue
+		}
+		forwards = append(forwards, forward)
+	}
+	return append(forwards, ipv6...), nil, nil
+}
+
+// AddPortForward creates the rule.
+func (c *FritzboxClient) AddPortForward(id SessionID, forward PortForward) error {
+	switch forward.Family {
+	case FamilyIPv4:
+		internalPort := forward.InternalPort
+		if internalPort == 0 {
+			internalPort = forward.Port
+		}
+		enabled := "0"
+		if forward.Enabled {
+			enabled = "1"
+		}
+		_, err := c.tr064Call(wanIPService, wanIPPath, "AddPortMapping", map[string]string{
+			"NewRemoteHost":             forward.RemoteHost,
+			"NewExternalPort":           strconv.Itoa(forward.Port),
+			"NewProtocol":               strings.ToUpper(forward.Protocol),
+			"NewInternalPort":           strconv.Itoa(internalPort),
+			"NewInternalClient":         forward.InternalClient,
+			"NewEnabled":                enabled,
+			"NewPortMappingDescription": forward.Description,
+			"NewLeaseDuration":          "0",
+		})
+		return err
+	case FamilyIPv6:
+		endPort := forward.EndPort
+		if endPort == 0 {
+			endPort = forward.Port
+		}
+		values := url.Values{
+			"xhr":        {"1"},
+			"sid":        {string(id)},
+			"page":       {"shareDo"},
+			"dev":        {forward.Device},
+			"rule":       {forward.Id},
+			"name":       {forward.Description},
+			"protocol":   {strings.ToUpper(forward.Protocol)},
+			"port":       {strconv.Itoa(forward.Port)},
+			"endPort":    {strconv.Itoa(endPort)},
+			"ip_version": {FamilyIPv6},
+			"apply":      {""},
+		}
+		if forward.Id == "" {
+			values.Set("rule", "new")
+		}
+		if forward.DualStack {
+			values.Set("ip_version", FamilyBoth)
+		}
+		if forward.Enabled {
+			values.Set("active", "on")
+		}
+		return c.applyForm(values)
+	}
+	return fmt.Errorf("unknown address family %q", forward.Family)
+}
+
+// DeletePortForward removes the rule.
+func (c *FritzboxClient) DeletePortForward(id SessionID, forward PortForward) error {
+	switch forward.Family {
+	case FamilyIPv4:
+		_, err := c.tr064Call(wanIPService, wanIPPath, "DeletePortMapping", map[string]string{
+			"NewRemoteHost":   forward.RemoteHost,
+			"NewExternalPort": strconv.Itoa(forward.Port),
+			"NewProtocol":     strings.ToUpper(forward.Protocol),
+		})
+		return err
+	case FamilyIPv6:
+		return c.applyForm(url.Values{
+			"xhr":    {"1"},
+			"sid":    {string(id)},
+			"page":   {"shareDo"},
+			"dev":    {forward.Device},
+			"delete": {forward.Id},
+			"apply":  {""},
+		})
+	}
+	return fmt.Errorf("unknown address family %q", forward.Family)
+}
+
+// UpdatePortForward replaces the rule current with wanted, which have the same
+// key. IPv4 mappings are deleted and added again, as the box rejects mappings
+// whose target changes. If adding the new mapping fails, the old one is
+// restored.
+func (c *FritzboxClient) UpdatePortForward(id SessionID, current PortForward, wanted PortForward) error {
+	wanted.Id = current.Id
+	if current.Family != FamilyIPv4 {
+		return c.AddPortForward(id, wanted)
+	}
+	if err := c.DeletePortForward(id, current); err != nil {
+		return err
+	}
+	if err := c.AddPortForward(id, wanted); err != nil {
+		if restoreErr := c.AddPortForward(id, current); restoreErr != nil {
+			return fmt.Errorf("%w, restoring the previous mapping failed: %w", err, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var ErrNotReady = errors.New("box not ready")
+
+const (
+	deviceConfigService = "urn:dslforum-org:service:DeviceConfig:1"
+	deviceConfigPath    = "/upnp/control/deviceconfig"
+	wanIPService        = "urn:dslforum-org:service:WANIPConnection:1"
+	wanIPPath           = "/upnp/control/wanipconnection1"
+)
+
+// ReadyOptions control WaitReady.
+type ReadyOptions struct {
+	// DownWithin is how long the box may take to go down after an operation
+	// that restarts it. Zero skips waiting for the box to go down.
+	DownWithin time.Duration
+	// Online additionally waits for the internet connection, which requires
+	// a login for TR-064.
+	Online  bool
+	Timeout time.Duration
+}
+
+// ReadyReport reports how long the phases of a restart took, each measured
+// from the start of WaitReady.
+type ReadyReport struct {
+	Restarted bool
+	Down      time.Duration
+	Reachable time.Duration
+	Online    time.Duration
+}
+
+// reachable reports whether the login page of the box responds.
+func (c *FritzboxClient) reachable() bool {
+	probe := &http.Client{Transport: c.httpClient.Transport, Timeout: 5 * time.Second}
+	resp, err := probe.Get(c.baseUrl.JoinPath("/login_sid.lua").String())
+	if err != nil {
+		return false
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// online reports whether the internet connection of the box is established.
+func (c *FritzboxClient) online() bool {
+	status, err := c.tr064Call(wanIPService, wanIPPath, "GetStatusInfo", nil)
+	return err == nil && status["NewConnectionStatus"] == "Connected"
+}
+
+// WaitReady waits until the box is ready again after an operation that
+// restarts it: first for it to go down, then for the login page to respond
+// and, optionally, for the internet connection to come up. It does not report
+// an error if the box did not go down within DownWithin, but Restarted is
+// false then.
+func (c *FritzboxClient) WaitReady(options ReadyOptions) (ReadyReport, error) {
+	var report ReadyReport
+	if options.Online && c.username == "" {
+		return report, ErrNoCredentials
+	}
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Minute
+	}
+	start := time.Now()
+	deadline := start.Add(timeout)
+
+	if options.DownWithin > 0 {
+		downDeadline := start.Add(min(options.DownWithin, timeout))
+		for !report.Restarted && time.Now().Before(downDeadline) {
+			if !c.reachable() {
+				report.Restarted = true
+				report.Down = time.Since(start)
+				break
+			}
+			time.Sleep(2 * time.Second)
+		}
+	}
+
+	for !c.reachable() {
+		if time.Now().After(deadline) {
+			return report, fmt.Errorf("%w: not reachable within %s", ErrNotReady, timeout)
+		}
+		time.Sleep(2 * time.Second)
+	}
+	report.Reachable = time.Since(start)
+
+	if options.Online {
+		for !c.online() {
+			if time.Now().After(deadline) {
+				return report, fmt.Errorf("%w: not online within %s", ErrNotReady, timeout)
+			}
+			time.Sleep(2 * time.Second)
+		}
+		report.Online = time.Since(start)
+	}
+	return report, nil
+}
+
+// Reboot restarts the box, through TR-064 if possible and otherwise through
+// the web interface.
+func (c *FritzboxClient) Reboot(id SessionID) error {
+	if c.username != "" {
+		if _, err := c.tr064Call(deviceConfigService, deviceConfigPath, "Reboot", nil); err == nil {
+			return nil
+		}
+	}
+
+	resp, err := c.httpClient.PostForm(c.baseUrl.JoinPath("/data.lua").String(), url.Values{
+		"xhr":    {"1"},
+		"sid":    {string(id)},
+		"page":   {"reboot"},
+		"reboot": {""},
+	})
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	rebootUrl := c.baseUrl.JoinPath("/reboot.lua")
+	rebootUrl.RawQuery = url.Values{"sid": {string(id)}, "ajax": {"1"}, "extern_reboot": {"1"}}.Encode()
+	if resp, err = c.httpClient.Get(rebootUrl.String()); err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status while rebooting: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// errRestNotFound is returned by restGet if the box does not know the
+// endpoint. Callers fall back to the web interface pages on any error.
+var errRestNotFound = errors.New("REST endpoint not found")
+
+// RestError is an error response of the REST API.
+type RestError struct {
+	Path   string
+	Status string
+}
+
+func (e *RestError) Error() string {
+	return fmt.Sprintf("unexpected status from %s: %s", e.Path, e.Status)
+}
+
+// useRest reports whether the REST API under /api/v0 should be used instead
+// of scraping the Lua pages.
+func (c *FritzboxClient) useRest(id SessionID) bool {
+	return id != "" && !c.restUnavailable && c.prefers(CapabilityRestAPI)
+}
+
+// restGet retrieves a JSON document from the REST API, authenticated by the
+// session id in the AVM-SID authorization header. A 404 makes the client
+// fall back to the Lua pages for the rest of its life.
+func (c *FritzboxClient) restGet(id SessionID, path string, v any) error {
+	requestUrl := c.baseUrl.JoinPath("/api/v0", path)
+	request, err := http.NewRequest(http.MethodGet, requestUrl.String(), nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "AVM-SID "+string(id))
+	request.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		c.restUnavailable = true
+		return errRestNotFound
+	default:
+		return &RestError{Path: requestUrl.Path, Status: resp.Status}
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// restGeneric retrieves a configuration module of the generic REST endpoint,
+// e.g. landevice, which returns an object with the list under the module name.
+func restGeneric[T any](c *FritzboxClient, id SessionID, module string) ([]T, error) {
+	var response map[string]json.RawMessage
+	if err := c.restGet(id, "/generic/"+module, &response); err != nil {
+		return nil, err
+	}
+	var entries []T
+	if raw, ok := response[module]; ok {
+		if err := json.Unmarshal(raw, &entries); err != nil {
+			return nil, fmt.Errorf("invalid %s response: %w", module, err)
+		}
+	}
+	return entries, nil
+}
+
+// restBoxInfo is the box module of the REST API.
+type restBoxInfo struct {
+	ProductName string `json:"productname"`
+	HWRevision  string `json:"hw_revision"`
+	Version     string `json:"version"`
+	Revision    string `json:"revision"`
+	Serial      string `json:"serial"`
+	OEM         string `json:"oem"`
+	Language    string `json:"language"`
+	Annex       string `json:"annex"`
+	Country     string `json:"country"`
+}
+
+func (c *FritzboxClient) restGetBoxInfo() (BoxInfo, error) {
+	var box restBoxInfo
+	if err := c.restGet(c.sid, "/generic/box", &box); err != nil {
+		return BoxInfo{}, err
+	}
+	return BoxInfo{
+		Name:       box.ProductName,
+		HWRevision: box.HWRevision,
+		Version:    box.Version,
+		Revision:   box.Revision,
+		Serial:     box.Serial,
+		OEM:        box.OEM,
+		Language:   box.Language,
+		Annex:      box.Annex,
+		Country:    box.Country,
+	}, nil
+}
+
+// restCompletePhoneNumbers fills in SIP account data the fon_num_list page
+// leaves empty from the sip module of the REST API. An account belongs to a
+// number if its node is the uid of the number and, where both are known, the
+// usernames agree. Identity fields like the uid, number and type are never
+// touched, and REST errors leave the numbers as they are.
+func (c *FritzboxClient) restCompletePhoneNumbers(id SessionID, phoneNumbers []PhoneNumber) {
+	accounts, err := restGeneric[SipData](c, id, "sip")
+	if err != nil {
+		return
+	}
+	byNode := make(map[string]SipData, len(accounts))
+	for _, account := range accounts {
+		if account.Node != "" {
+			byNode[account.Node] = account
+		}
+	}
+	for i := range phoneNumbers {
+		phoneNumber := &phoneNumbers[i]
+		if phoneNumber.Type != "sip" || phoneNumber.Uid == "" {
+			continue
+		}
+		account, ok := byNode[phoneNumber.Uid]
+		if !ok {
+			continue
+		}
+		if phoneNumber.Sip.Username != "" && account.Username != "" && phoneNumber.Sip.Username != account.Username {
+			continue
+		}
+		if phoneNumber.Registrar == "" {
+			phoneNumber.Registrar = account.Registrar
+		}
+		if phoneNumber.OutboundProxy == "" {
+			phoneNumber.OutboundProxy = account.OutboundProxy
+		}
+		if phoneNumber.ProviderName == "" {
+			phoneNumber.ProviderName = account.ProviderName
+		}
+		if phoneNumber.Sip.Username == "" {
+			phoneNumber.Sip.Username = account.Username
+		}
+		if phoneNumber.Sip.DisplayName == "" {
+			phoneNumber.Sip.DisplayName = account.DisplayName
+		}
+	}
+}
+
+// LanDevice is a device in the home network known to the box.
+type LanDevice struct {
+	UID       string
+	Name      string
+	MAC       string
+	IPv4      string
+	IPv6      []string
+	Interface string
+	Active    bool
+	// Speed is the link speed in Mbit/s, if known.
+	Speed    int
+	LastSeen time.Time
+}
+
+// restLanDevice is an entry of the landevice module of the REST API.
+type restLanDevice struct {
+	UID      string   `json:"UID"`
+	Name     string   `json:"name"`
+	MAC      string   `json:"mac"`
+	IP       string   `json:"ip"`
+	IPv6     []string `json:"ipv6addrs"`
+	Active   FlexBool `json:"active"`
+	Ethernet FlexBool `json:"ethernet"`
+	WLAN     FlexBool `json:"wlan"`
+	Speed    string   `json:"speed"`
+	LastUsed string   `json:"lastused"`
+}
+
+func (d restLanDevice) lanDevice() LanDevice {
+	device := LanDevice{
+		UID:    d.UID,
+		Name:   d.Name,
+		MAC:    d.MAC,
+		IPv4:   d.IP,
+		IPv6:   d.IPv6,
+		Active: d.Active.Bool(),
+	}
+	switch {
+	case d.WLAN.Bool():
+		device.Interface = "wlan"
+	case d.Ethernet.Bool():
+		device.Interface = "ethernet"
+	}
+	device.Speed, _ = strconv.Atoi(d.Speed)
+	if lastUsed, err := strconv.ParseInt(d.LastUsed, 10, 64); err == nil && lastUsed > 0 {
+		device.LastSeen = time.Unix(lastUsed, 0)
+	}
+	return device
+}
+
+// netDevEntry is a device of the netDev page of data.lua.
+type netDevEntry struct {
+	UID  string `json:"UID"`
+	Name string `json:"name"`
+	MAC  string `json:"mac"`
+	IPv4 struct {
+		IP       string `json:"ip"`
+		LastUsed int64  `json:"lastused"`
+	} `json:"ipv4"`
+	IPv6 struct {
+		IP string `json:"ip"`
+	} `json:"ipv6"`
+	Type       string `json:"type"`
+	Properties []struct {
+		Text string `json:"txt"`
+	} `json:"properties"`
+}
+
+func (d netDevEntry) lanDevice(active bool) LanDevice {
+	device := LanDevice{
+		UID:       d.UID,
+		Name:      d.Name,
+		MAC:       d.MAC,
+		IPv4:      d.IPv4.IP,
+		Interface: d.Type,
+		Active:    active,
+	}
+	if d.IPv6.IP != "" {
+		device.IPv6 = []string{d.IPv6.IP}
+	}
+	if d.IPv4.LastUsed > 0 {
+		device.LastSeen = time.Unix(d.IPv4.LastUsed, 0)
+	}
+	for _, property := range d.Properties {
+		var speed int
+		if _, err := fmt.Sscanf(property.Text, "%d Mbit/s", &speed); err == nil {
+			device.Speed = speed
+		}
+	}
+	return device
+}
+
+// ListLanDevices lists the devices in the home network through the REST API
+// or the netDev page of data.lua on older firmware.
+func (c *FritzboxClient) ListLanDevices(id SessionID) ([]LanDevice, error) {
+	if c.useRest(id) {
+		// Any REST error falls back to the netDev page.
+		if entries, err := restGeneric[restLanDevice](c, id, "landevice"); err == nil {
+			devices := make([]LanDevice, 0, len(entries))
+			for _, entry := range entries {
+				devices = append(devices, entry.lanDevice())
+			}
+			return devices, nil
+		}
+	}
+
+	resp, err := c.httpClient.PostForm(c.baseUrl.JoinPath("/data.lua").String(), url.Values{
+		"xhr":   {"1"},
+		"sid":   {string(id)},
+		"page":  {"netDev"},
+		"xhrId": {"all"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var response struct {
+		Data struct {
+			Active  []netDevEntry `json:"active"`
+			Passive []netDevEntry `json:"passive"`
+		} `json:"data"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	devices := make([]LanDevice, 0, len(response.Data.Active)+len(response.Data.Passive))
+	for _, entry := range response.Data.Active {
+		devices = append(devices, entry.lanDevice(true))
+	}
+	for _, entry := range response.Data.Passive {
+		devices = append(devices, entry.lanDevice(false))
+	}
+	return devices, nil
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+var ErrNoCredentials = errors.New("TR-064 requires logging in with username and password first")
+
+// TR064Error is a UPnP error returned by a TR-064 action.
+type TR064Error struct {
+	Action      string
+	Code        string
+	Description string
+}
+
+func (e *TR064Error) Error() string {
+	return fmt.Sprintf("%s failed: %s (%s)", e.Action, e.Description, e.Code)
+}
+
+type tr064Argument struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+type tr064Envelope struct {
+	Body struct {
+		Response struct {
+			Arguments []tr064Argument `xml:",any"`
+		} `xml:",any"`
+		Fault *struct {
+			Code        string `xml:"detail>UPnPError>errorCode"`
+			Description string `xml:"detail>UPnPError>errorDescription"`
+		} `xml:"Fault"`
+	} `xml:"Body"`
+}
+
+// tr064Url returns the URL of a TR-064 control path: port 49000 for plain
+// HTTP and 49443 for HTTPS.
+func (c *FritzboxClient) tr064Url(controlPath string) string {
+	port := "49000"
+	if c.baseUrl.Scheme == "https" {
+		port = "49443"
+	}
+	tr064Url := url.URL{Scheme: c.baseUrl.Scheme, Host: net.JoinHostPort(c.baseUrl.Hostname(), port), Path: controlPath}
+	return tr064Url.String()
+}
+
+// digestAuthorization answers an HTTP digest challenge (RFC 2617, MD5 with
+// qop auth), which TR-064 uses instead of sessions.
+func digestAuthorization(challenge string, method string, uri string, username string, password string) (string, error) {
+	if !strings.HasPrefix(challenge, "Digest ") {
+		return "", fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+	parameters := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(challenge, "Digest "), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		parameters[strings.ToLower(name)] = strings.Trim(value, `"`)
+	}
+	hash := func(value string) string {
+		sum := md5.Sum([]byte(value))
+		return hex.EncodeToString(sum[:])
+	}
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	clientNonce := hex.EncodeToString(nonce)
+	ha1 := hash(username + ":" + parameters["realm"] + ":" + password)
+	ha2 := hash(method + ":" + uri)
+	response := hash(ha1 + ":" + parameters["nonce"] + ":00000001:" + clientNonce + ":auth:" + ha2)
+	authorization := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", qop=auth, nc=00000001, cnonce="%s", response="%s", algorithm=MD5`,
+		username, parameters["realm"], parameters["nonce"], uri, clientNonce, response)
+	if opaque, ok := parameters["opaque"]; ok {
+		authorization += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	return authorization, nil
+}
+
+func (c *FritzboxClient) tr064Request(requestUrl string, serviceType string, action string, body []byte, authorization string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, requestUrl, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SoapAction", serviceType+"#"+action)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return c.httpClient.Do(req)
+}
+
+// tr064Call invokes a TR-064 action with the credentials of the last login
+// and returns the output arguments.
+func (c *FritzboxClient) tr064Call(serviceType string, controlPath string, action string, arguments map[string]string) (map[string]string, error) {
+	if c.username == "" {
+		return nil, ErrNoCredentials
+	}
+
+	body := new(bytes.Buffer)
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	body.WriteString(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(body, `<u:%s xmlns:u="%s">`, action, serviceType)
+	names := make([]string, 0, len(arguments))
+	for name := range arguments {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(body, "<%s>", name)
+		if err := xml.EscapeText(body, []byte(arguments[name])); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(body, "</%s>", name)
+	}
+	fmt.Fprintf(body, `</u:%s></s:Body></s:Envelope>`, action)
+
+	requestUrl := c.tr064Url(controlPath)
+	resp, err := c.tr064Request(requestUrl, serviceType, action, body.Bytes(), "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		var authorization string
+		if authorization, err = digestAuthorization(resp.Header.Get("WWW-Authenticate"), http.MethodPost, controlPath, c.username, c.password); err != nil {
+			return nil, err
+		}
+		if resp, err = c.tr064Request(requestUrl, serviceType, action, body.Bytes(), authorization); err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+
+	var envelope tr064Envelope
+	if err = xml.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("%s failed: %s", action, resp.Status)
+	}
+	if envelope.Body.Fault != nil {
+		return nil, &TR064Error{Action: action, Code: envelope.Body.Fault.Code, Description: envelope.Body.Fault.Description}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s failed: %s", action, resp.Status)
+	}
+	result := map[string]string{}
+	for _, argument := range envelope.Body.Response.Arguments {
+		result[argument.XMLName.Local] = argument.Value
+	}
+	return result, nil
+}
@@ -0,0 +1,355 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FlexBool is a boolean that different firmware versions emit either as JSON
+// boolean, as number or as string ("0"/"1", "on", "true", ""). It is encoded
+// back in the "0"/"1" form the web interface uses. Values in other forms are
+// kept as they are, so that they survive a round trip; numbers count as true
+// unless they are zero, other values as false.
+type FlexBool struct {
+	value bool
+	raw   interface{}
+}
+
+func NewFlexBool(value bool) FlexBool {
+	return FlexBool{value: value}
+}
+
+func (b FlexBool) Bool() bool {
+	return b.value
+}
+
+func (b FlexBool) String() string {
+	if b.raw != nil {
+		return fmt.Sprint(b.raw)
+	}
+	return strconv.FormatBool(b.value)
+}
+
+func parseFlexBool(value interface{}) FlexBool {
+	switch value := value.(type) {
+	case nil:
+		return FlexBool{}
+	case bool:
+		return FlexBool{value: value}
+	case float64:
+		if value == 0 || value == 1 {
+			return FlexBool{value: value == 1}
+		}
+		return FlexBool{value: true, raw: value}
+	case int:
+		if value == 0 || value == 1 {
+			return FlexBool{value: value == 1}
+		}
+		return FlexBool{value: true, raw: value}
+	case string:
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "", "0", "false", "off", "no":
+			return FlexBool{}
+		case "1", "true", "on", "yes":
+			return FlexBool{value: true}
+		}
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			return FlexBool{value: number != 0, raw: value}
+		}
+	}
+	return FlexBool{raw: value}
+}
+
+func (b FlexBool) MarshalJSON() ([]byte, error) {
+	if b.raw != nil {
+		return json.Marshal(b.raw)
+	}
+	if b.value {
+		return []byte(`"1"`), nil
+	}
+	return []byte(`"0"`), nil
+}
+
+func (b *FlexBool) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*b = parseFlexBool(value)
+	return nil
+}
+
+func (b FlexBool) MarshalYAML() (interface{}, error) {
+	if b.raw != nil {
+		return b.raw, nil
+	}
+	return b.value, nil
+}
+
+func (b *FlexBool) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value interface{}
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	*b = parseFlexBool(value)
+	return nil
+}
+
+// enumValue is the value of an enum field: the numeric code the firmware uses
+// or, for values that are neither a code nor a known name, the raw value as
+// it was received, so that it survives a round trip.
+type enumValue struct {
+	code int
+	raw  interface{}
+}
+
+// enumNames maps the numeric codes the firmware uses for an enum to readable
+// names. Codes without a name are kept as numbers.
+type enumNames map[int]string
+
+func (n enumNames) format(value enumValue) string {
+	if value.raw != nil {
+		return fmt.Sprint(value.raw)
+	}
+	if name, ok := n[value.code]; ok {
+		return name
+	}
+	return strconv.Itoa(value.code)
+}
+
+// value returns the form of the value the firmware uses: the number, or the
+// raw value for unknown values.
+func (n enumNames) value(value enumValue) string {
+	if value.raw != nil {
+		return fmt.Sprint(value.raw)
+	}
+	return strconv.Itoa(value.code)
+}
+
+func (n enumNames) parse(value interface{}) enumValue {
+	switch value := value.(type) {
+	case nil:
+		return enumValue{}
+	case float64:
+		if value == float64(int(value)) && value >= 0 {
+			return enumValue{code: int(value)}
+		}
+	case int:
+		if value >= 0 {
+			return enumValue{code: value}
+		}
+	case string:
+		trimmed := strings.TrimSpace(value)
+		if code, err := strconv.Atoi(trimmed); err == nil && code >= 0 && strconv.Itoa(code) == trimmed {
+			return enumValue{code: code}
+		}
+		for code, name := range n {
+			if trimmed != "" && strings.EqualFold(name, trimmed) {
+				return enumValue{code: code}
+			}
+		}
+	}
+	return enumValue{raw: value}
+}
+
+func (n enumNames) marshalJSON(value enumValue) ([]byte, error) {
+	if value.raw != nil {
+		return json.Marshal(value.raw)
+	}
+	return json.Marshal(strconv.Itoa(value.code))
+}
+
+func (n enumNames) unmarshalJSON(data []byte) (enumValue, error) {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return enumValue{}, err
+	}
+	return n.parse(value), nil
+}
+
+func (n enumNames) marshalYAML(value enumValue) (interface{}, error) {
+	if value.raw != nil {
+		return value.raw, nil
+	}
+	return n.format(value), nil
+}
+
+func (n enumNames) unmarshalYAML(unmarshal func(interface{}) error) (enumValue, error) {
+	var value interface{}
+	if err := unmarshal(&value); err != nil {
+		return enumValue{}, err
+	}
+	return n.parse(value), nil
+}
+
+// TransportType is the transport protocol used for SIP signalling.
+type TransportType struct {
+	enumValue
+}
+
+var (
+	TransportUDP  = TransportType{enumValue{code: 0}}
+	TransportTCP  = TransportType{enumValue{code: 1}}
+	TransportTLS  = TransportType{enumValue{code: 2}}
+	TransportAuto = TransportType{enumValue{code: 3}}
+)
+
+var transportTypeNames = enumNames{
+	TransportUDP.code:  "udp",
+	TransportTCP.code:  "tcp",
+	TransportTLS.code:  "tls",
+	TransportAuto.code: "auto",
+}
+
+func (t TransportType) String() string {
+	return transportTypeNames.format(t.enumValue)
+}
+
+func (t TransportType) MarshalJSON() ([]byte, error) {
+	return transportTypeNames.marshalJSON(t.enumValue)
+}
+
+func (t *TransportType) UnmarshalJSON(data []byte) error {
+	value, err := transportTypeNames.unmarshalJSON(data)
+	t.enumValue = value
+	return err
+}
+
+func (t TransportType) MarshalYAML() (interface{}, error) {
+	return transportTypeNames.marshalYAML(t.enumValue)
+}
+
+func (t *TransportType) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	value, err := transportTypeNames.unmarshalYAML(unmarshal)
+	t.enumValue = value
+	return err
+}
+
+// DtmfMode is the way DTMF tones are transmitted.
+type DtmfMode struct {
+	enumValue
+}
+
+var (
+	DtmfAuto    = DtmfMode{enumValue{code: 0}}
+	DtmfInband  = DtmfMode{enumValue{code: 1}}
+	DtmfRFC2833 = DtmfMode{enumValue{code: 2}}
+	DtmfSipInfo = DtmfMode{enumValue{code: 3}}
+)
+
+var dtmfModeNames = enumNames{
+	DtmfAuto.code:    "auto",
+	DtmfInband.code:  "inband",
+	DtmfRFC2833.code: "rfc2833",
+	DtmfSipInfo.code: "sip-info",
+}
+
+func (m DtmfMode) String() string {
+	return dtmfModeNames.format(m.enumValue)
+}
+
+func (m DtmfMode) MarshalJSON() ([]byte, error) {
+	return dtmfModeNames.marshalJSON(m.enumValue)
+}
+
+func (m *DtmfMode) UnmarshalJSON(data []byte) error {
+	value, err := dtmfModeNames.unmarshalJSON(data)
+	m.enumValue = value
+	return err
+}
+
+func (m DtmfMode) MarshalYAML() (interface{}, error) {
+	return dtmfModeNames.marshalYAML(m.enumValue)
+}
+
+func (m *DtmfMode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	value, err := dtmfModeNames.unmarshalYAML(unmarshal)
+	m.enumValue = value
+	return err
+}
+
+// ClirType is the way calling line identification restriction is signalled.
+type ClirType struct {
+	enumValue
+}
+
+var (
+	ClirAuto          = ClirType{enumValue{code: 0}}
+	ClirPrivacyHeader = ClirType{enumValue{code: 1}}
+	ClirFromAnonymous = ClirType{enumValue{code: 2}}
+	ClirNone          = ClirType{enumValue{code: 3}}
+)
+
+var clirTypeNames = enumNames{
+	ClirAuto.code:          "auto",
+	ClirPrivacyHeader.code: "privacy-header",
+	ClirFromAnonymous.code: "from-anonymous",
+	ClirNone.code:          "none",
+}
+
+func (t ClirType) String() string {
+	return clirTypeNames.format(t.enumValue)
+}
+
+func (t ClirType) MarshalJSON() ([]byte, error) {
+	return clirTypeNames.marshalJSON(t.enumValue)
+}
+
+func (t *ClirType) UnmarshalJSON(data []byte) error {
+	value, err := clirTypeNames.unmarshalJSON(data)
+	t.enumValue = value
+	return err
+}
+
+func (t ClirType) MarshalYAML() (interface{}, error) {
+	return clirTypeNames.marshalYAML(t.enumValue)
+}
+
+func (t *ClirType) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	value, err := clirTypeNames.unmarshalYAML(unmarshal)
+	t.enumValue = value
+	return err
+}
+
+// SrtpMode is the way SRTP is offered in the SDP of calls.
+type SrtpMode struct {
+	enumValue
+}
+
+var (
+	SrtpAvp  = SrtpMode{enumValue{code: 0}}
+	SrtpSavp = SrtpMode{enumValue{code: 1}}
+	SrtpBoth = SrtpMode{enumValue{code: 2}}
+)
+
+var srtpModeNames = enumNames{
+	SrtpAvp.code:  "rtp-avp",
+	SrtpSavp.code: "rtp-savp",
+	SrtpBoth.code: "both",
+}
+
+func (m SrtpMode) String() string {
+	return srtpModeNames.format(m.enumValue)
+}
+
+func (m SrtpMode) MarshalJSON() ([]byte, error) {
+	return srtpModeNames.marshalJSON(m.enumValue)
+}
+
+func (m *SrtpMode) UnmarshalJSON(data []byte) error {
+	value, err := srtpModeNames.unmarshalJSON(data)
+	m.enumValue = value
+	return err
+}
+
+func (m SrtpMode) MarshalYAML() (interface{}, error) {
+	return srtpModeNames.marshalYAML(m.enumValue)
+}
+
+func (m *SrtpMode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	value, err := srtpModeNames.unmarshalYAML(unmarshal)
+	m.enumValue = value
+	return err
+}
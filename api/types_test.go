@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/json"
+	"gopkg.in/yaml.v3"
+	"testing"
+)
+
+type roundTripData struct {
+	Active    FlexBool      `json:"active" yaml:"active"`
+	Transport TransportType `json:"transport_type" yaml:"transport_type"`
+	Dtmf      DtmfMode      `json:"dtmfcfg" yaml:"dtmfcfg"`
+	Clir      ClirType      `json:"clirtype" yaml:"clirtype"`
+	Srtp      SrtpMode      `json:"crypto_avp_mode" yaml:"crypto_avp_mode"`
+}
+
+func TestFlexBoolParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{`true`, true},
+		{`false`, false},
+		{`null`, false},
+		{`1`, true},
+		{`0`, false},
+		{`"1"`, true},
+		{`"0"`, false},
+		{`""`, false},
+		{`"on"`, true},
+		{`"off"`, false},
+		{`"TRUE"`, true},
+		{`2`, true},
+		{`"2"`, true},
+		{`"enabled"`, false},
+	}
+	for _, test := range tests {
+		var value FlexBool
+		if err := json.Unmarshal([]byte(test.input), &value); err != nil {
+			t.Errorf("%s: %v", test.input, err)
+			continue
+		}
+		if value.Bool() != test.want {
+			t.Errorf("%s: got %t, want %t", test.input, value.Bool(), test.want)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		// Known values are written in the form of the web interface.
+		{
+			`{"active":true,"transport_type":"1","dtmfcfg":2,"clirtype":"0","crypto_avp_mode":"2"}`,
+			`{"active":"1","transport_type":"1","dtmfcfg":"2","clirtype":"0","crypto_avp_mode":"2"}`,
+		},
+		{
+			`{"active":"on","transport_type":"tls","dtmfcfg":"sip-info","clirtype":"none","crypto_avp_mode":"both"}`,
+			`{"active":"1","transport_type":"2","dtmfcfg":"3","clirtype":"3","crypto_avp_mode":"2"}`,
+		},
+		// Unknown values are kept as they are.
+		{
+			`{"active":"2","transport_type":"7","dtmfcfg":"weird","clirtype":"-1","crypto_avp_mode":"rtp-new"}`,
+			`{"active":"2","transport_type":"7","dtmfcfg":"weird","clirtype":"-1","crypto_avp_mode":"rtp-new"}`,
+		},
+		{
+			`{"active":"maybe","transport_type":"","dtmfcfg":null,"clirtype":"0","crypto_avp_mode":"0"}`,
+			`{"active":"maybe","transport_type":"","dtmfcfg":"0","clirtype":"0","crypto_avp_mode":"0"}`,
+		},
+		{
+			`{"active":"1","transport_type":" 1","dtmfcfg":"01","clirtype":-1,"crypto_avp_mode":1.5}`,
+			`{"active":"1","transport_type":"1","dtmfcfg":"01","clirtype":-1,"crypto_avp_mode":1.5}`,
+		},
+	}
+	for _, test := range tests {
+		var data roundTripData
+		if err := json.Unmarshal([]byte(test.input), &data); err != nil {
+			t.Errorf("%s: %v", test.input, err)
+			continue
+		}
+		output, err := json.Marshal(data)
+		if err != nil {
+			t.Errorf("%s: %v", test.input, err)
+			continue
+		}
+		if string(output) != test.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", test.input, output, test.want)
+		}
+	}
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	var data roundTripData
+	input := `{"active":"2","transport_type":"7","dtmfcfg":"weird","clirtype":"1","crypto_avp_mode":"rtp-new"}`
+	if err := json.Unmarshal([]byte(input), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Transport.String() != "7" || data.Dtmf.String() != "weird" || data.Clir.String() != "privacy-header" {
+		t.Errorf("unexpected names %s, %s, %s", data.Transport, data.Dtmf, data.Clir)
+	}
+
+	document, err := yaml.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed roundTripData
+	if err = yaml.Unmarshal(document, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if parsed != data {
+		t.Errorf("YAML round trip changed %+v to %+v:\n%s", data, parsed, document)
+	}
+
+	output, err := json.Marshal(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != `{"active":"2","transport_type":"7","dtmfcfg":"weird","clirtype":"1","crypto_avp_mode":"rtp-new"}` {
+		t.Errorf("JSON after YAML round trip is %s", output)
+	}
+}
+
+func TestEnumUnknownValues(t *testing.T) {
+	var first, second roundTripData
+	if err := json.Unmarshal([]byte(`{"transport_type":"quic","dtmfcfg":"weird"}`), &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(`{"transport_type":"weird","dtmfcfg":"quic"}`), &second); err != nil {
+		t.Fatal(err)
+	}
+	if first.Transport.String() != "quic" || second.Transport.String() != "weird" || first.Dtmf.String() != "weird" || second.Dtmf.String() != "quic" {
+		t.Errorf("unknown values were mixed up: %v, %v, %v, %v", first.Transport, second.Transport, first.Dtmf, second.Dtmf)
+	}
+	if first.Transport == second.Transport || first.Transport == TransportUDP {
+		t.Error("different unknown values compare equal")
+	}
+	if len(transportTypeNames) != 4 || len(dtmfModeNames) != 4 {
+		t.Error("unknown values were added to the names")
+	}
+	if transportTypeNames.value(first.Transport.enumValue) != "quic" || transportTypeNames.value(TransportTLS.enumValue) != "2" {
+		t.Error("unexpected form values")
+	}
+}
@@ -2,15 +2,19 @@ package api
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/andybalholm/cascadia"
+	"golang.org/x/crypto/pbkdf2"
 	"golang.org/x/net/html"
 	"golang.org/x/text/encoding/unicode"
 	"golang.org/x/text/transform"
 	"io"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -39,18 +43,26 @@ var updateMessageSelector = cascadia.MustCompile("form[name=mainform] > p")
 var javascriptSelector = cascadia.MustCompile("script[type=module]")
 var jsFunctionSelector = regexp.MustCompile(`postUpload\.redirect\(([0-9]*)\);`)
 
-func parseUpdateResponse(resp *http.Response) (string, error) {
-	var err error
-	var document *html.Node
-	if document, err = html.Parse(resp.Body); err != nil {
-		return "", err
+// parseUploadMessage returns the parsed result page of a firmwarecfg upload
+// and the message it shows.
+func parseUploadMessage(resp *http.Response) (*html.Node, string, error) {
+	document, err := html.Parse(resp.Body)
+	if err != nil {
+		return nil, "", err
 	}
 
 	updateMessageNode := cascadia.Query(document, updateMessageSelector)
 	if updateMessageNode == nil {
-		return "", fmt.Errorf("unable to find update message in document")
+		return document, "", fmt.Errorf("unable to find update message in document")
+	}
+	return document, strings.TrimSpace(innerText(updateMessageNode)), nil
+}
+
+func parseUpdateResponse(resp *http.Response) (string, error) {
+	document, updateMessage, err := parseUploadMessage(resp)
+	if err != nil {
+		return "", err
 	}
-	updateMessage := strings.TrimSpace(innerText(updateMessageNode))
 
 	javascriptNode := cascadia.Query(document, javascriptSelector)
 	if javascriptNode == nil {
@@ -67,3 +79,22 @@ func parseUpdateResponse(resp *http.Response) (string, error) {
 
 	return updateMessage, nil
 }
+
+// pbkdf2ChallengeResponse answers a challenge of the form
+// 2$<iter1>$<salt1>$<iter2>$<salt2>, as offered since FRITZ!OS 7.24.
+func pbkdf2ChallengeResponse(challenge string, password string) (string, error) {
+	parts := strings.Split(challenge, "$")
+	if len(parts) != 5 {
+		return "", fmt.Errorf("invalid challenge %q", challenge)
+	}
+	iter1, err1 := strconv.Atoi(parts[1])
+	salt1, err2 := hex.DecodeString(parts[2])
+	iter2, err3 := strconv.Atoi(parts[3])
+	salt2, err4 := hex.DecodeString(parts[4])
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return "", fmt.Errorf("invalid challenge %q: %w", challenge, err)
+	}
+	hash1 := pbkdf2.Key([]byte(password), salt1, iter1, sha256.Size, sha256.New)
+	hash2 := pbkdf2.Key(hash1, salt2, iter2, sha256.Size, sha256.New)
+	return fmt.Sprintf("%s$%s", parts[4], hex.EncodeToString(hash2)), nil
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"fritzbox-client/api"
+	"fritzbox-client/export"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+type backupCommand struct {
+	Export  *backupExportCommand  `arg:"subcommand:export"`
+	Restore *backupRestoreCommand `arg:"subcommand:restore"`
+	Inspect *backupInspectCommand `arg:"subcommand:inspect"`
+	Diff    *backupDiffCommand    `arg:"subcommand:diff"`
+}
+
+type backupExportCommand struct {
+	PasswordFrom string `arg:"--password-from,required" placeholder:"<file:PATH|env:NAME|stdin>"`
+	Output       string `arg:"-o,--output,required" placeholder:"<file|dir>"`
+	Keep         int    `arg:"--keep" placeholder:"count"`
+}
+
+type backupRestoreCommand struct {
+	File                string               `arg:"-f,--file,required" placeholder:"file"`
+	PasswordFrom        string               `arg:"--password-from,required" placeholder:"<file:PATH|env:NAME|stdin>"`
+	Sections            []api.RestoreSection `arg:"--section,separate" placeholder:"<internet|telephony|wlan|dect|smarthome>"`
+	ConfirmationTimeout time.Duration        `arg:"--confirmation-timeout" default:"2m" placeholder:"duration"`
+	Timeout             time.Duration        `arg:"--timeout" default:"5m" placeholder:"duration"`
+}
+
+type backupInspectCommand struct {
+	File         string `arg:"positional,required" placeholder:"file"`
+	PasswordFrom string `arg:"--password-from" placeholder:"<file:PATH|env:NAME|stdin>"`
+	Section      string `arg:"--section" placeholder:"name"`
+}
+
+type backupDiffCommand struct {
+	Old             string `arg:"positional,required" placeholder:"old"`
+	New             string `arg:"positional,required" placeholder:"new"`
+	PasswordFrom    string `arg:"--password-from" placeholder:"<file:PATH|env:NAME|stdin>"`
+	NewPasswordFrom string `arg:"--new-password-from" placeholder:"<file:PATH|env:NAME|stdin>"`
+}
+
+func (c *backupCommand) task() string {
+	switch {
+	case c.Export != nil:
+		return "export"
+	case c.Restore != nil:
+		return "restore"
+	case c.Inspect != nil:
+		return "inspect"
+	case c.Diff != nil:
+		return "diff"
+	default:
+		return ""
+	}
+}
+
+func commandBackup(options args) error {
+	switch options.Backup.task() {
+	case "export":
+		return backupExport(options, options.Backup.Export)
+	case "restore":
+		return backupRestore(options, options.Backup.Restore)
+	case "inspect":
+		return backupInspect(options.Backup.Inspect)
+	case "diff":
+		return backupDiff(options.Backup.Diff)
+	}
+	return nil
+}
+
+var unsafeFilenameCharacters = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// backupHost returns the host name of the box address given with --host.
+func backupHost(hostname string) string {
+	if parsedUrl, err := url.Parse(hostname); err == nil && parsedUrl.Hostname() != "" {
+		return parsedUrl.Hostname()
+	}
+	return hostname
+}
+
+// backupPrefix returns the start of the file names of backups of a box, which
+// is identified by its model and host.
+func backupPrefix(model string, host string) string {
+	prefix := strings.Trim(unsafeFilenameCharacters.ReplaceAllString(model, "_"), "_") + "_"
+	return prefix + strings.Trim(unsafeFilenameCharacters.ReplaceAllString(host, "_"), "_") + "_"
+}
+
+// backupFilename names a backup by model, host, firmware version and date,
+// e.g. FRITZ_Box_7590_fritz.box_154.07.57_2024-05-01_120000.export.
+func backupFilename(configuration *export.Export, host string, date time.Time) string {
+	firmware := unsafeFilenameCharacters.ReplaceAllString(configuration.FirmwareVersion(), "_")
+	return fmt.Sprintf("%s%s_%s.export", backupPrefix(configuration.Model, host), firmware, date.Format("2006-01-02_150405"))
+}
+
+// backupPattern matches exactly the file names backupFilename gives backups of
+// the box, so that backups of a FRITZ!Box 7590 AX are not taken for ones of a
+// FRITZ!Box 7590.
+func backupPattern(model string, host string) *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(backupPrefix(model, host)) + `[A-Za-z0-9._-]+_\d{4}-\d{2}-\d{2}_\d{6}\.export$`)
+}
+
+// rotateBackups deletes all but the newest keep backups of the box in dir.
+func rotateBackups(dir string, model string, host string, keep int) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	pattern := backupPattern(model, host)
+	type backup struct {
+		path     string
+		modified time.Time
+	}
+	var backups []backup
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !pattern.MatchString(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, backup{path: filepath.Join(dir, entry.Name()), modified: info.ModTime()})
+	}
+	slices.SortFunc(backups, func(a, b backup) int {
+		return b.modified.Compare(a.modified)
+	})
+
+	var removed []string
+	for i := keep; i < len(backups); i++ {
+		if err = os.Remove(backups[i].path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, backups[i].path)
+	}
+	return removed, nil
+}
+
+// writeFileAtomic writes the file next to its destination first, so that an
+// interrupted download never replaces a previous backup with a partial one.
+func writeFileAtomic(path string, data []byte) error {
+	temporary := path + ".tmp"
+	if err := os.WriteFile(temporary, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(temporary, path); err != nil {
+		_ = os.Remove(temporary)
+		return err
+	}
+	return nil
+}
+
+func backupExport(options args, command *backupExportCommand) error {
+	var err error
+
+	info, statErr := os.Stat(command.Output)
+	toDirectory := statErr == nil && info.IsDir()
+	if command.Keep > 0 && !toDirectory {
+		err = errors.New("--keep requires --output to be an existing directory")
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+
+	var password string
+	if password, err = readSecret(command.PasswordFrom); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	if password == "" {
+		err = errors.New("the export password must not be empty, secrets could not be restored")
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+
+	client, sessionInfo, err := login(options)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print("Exporting configuration… ")
+	var data []byte
+	if data, err = client.ExportConfiguration(sessionInfo.Sid, password); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Printf("Done, %d bytes.\n", len(data))
+
+	fmt.Print("Validating export… ")
+	var configuration *export.Export
+	if configuration, err = export.Parse(data); err == nil {
+		err = configuration.Verify()
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Printf("Done, %s with firmware %s, %d sections.\n", configuration.Model, configuration.FirmwareVersion(), len(configuration.Sections))
+
+	path := command.Output
+	if toDirectory {
+		path = filepath.Join(command.Output, backupFilename(configuration, backupHost(options.Hostname), time.Now()))
+	}
+	fmt.Printf("Writing export to %s… ", path)
+	if err = writeFileAtomic(path, data); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Println("Done.")
+
+	if command.Keep > 0 {
+		fmt.Printf("Rotating backups, keeping %d… ", command.Keep)
+		var removed []string
+		removed, err = rotateBackups(command.Output, configuration.Model, backupHost(options.Hostname), command.Keep)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		fmt.Printf("Done, removed %d.\n", len(removed))
+		for _, path := range removed {
+			fmt.Printf("Removed %s.\n", path)
+		}
+	}
+
+	return nil
+}
+
+func backupRestore(options args, command *backupRestoreCommand) error {
+	var err error
+
+	for _, section := range command.Sections {
+		if !slices.Contains(api.RestoreSections, section) {
+			err = fmt.Errorf("unknown restore section %q", section)
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+	}
+
+	var data []byte
+	if data, _, err = loadExport(command.File, os.Stdout); err != nil {
+		return err
+	}
+
+	var password string
+	if password, err = readSecret(command.PasswordFrom); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+
+	client, sessionInfo, err := login(options)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print("Restoring configuration and waiting for the box to restart… ")
+	var result api.RestoreResult
+	restoreOptions := api.RestoreOptions{
+		Password: password,
+		Sections: command.Sections,
+		Confirm: func() {
+			fmt.Print("confirm the restore on the box by pressing a button or dialing the code shown… ")
+		},
+		ConfirmationTimeout: command.ConfirmationTimeout,
+		Wait:                true,
+		Timeout:             command.Timeout,
+	}
+	if result, err = client.ImportConfiguration(sessionInfo.Sid, bytes.NewReader(data), restoreOptions); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	if result.Message != "" {
+		fmt.Printf("Done: %s\n", result.Message)
+	} else {
+		fmt.Println("Done.")
+	}
+	if result.Ready.Restarted {
+		printReadyReport(os.Stdout, result.Ready, false)
+	} else {
+		fmt.Println("The box did not restart.")
+	}
+
+	// The restored configuration may contain other credentials.
+	if _, _, err = login(options); err != nil {
+		fmt.Println("Login failed after the restore, the restored configuration may use other credentials.")
+		return err
+	}
+	return nil
+}
+
+// loadExport reads and verifies an export file.
+func loadExport(path string, progress io.Writer) ([]byte, *export.Export, error) {
+	_, _ = fmt.Fprintf(progress, "Loading %s… ", path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+		return nil, nil, err
+	}
+	var configuration *export.Export
+	if configuration, err = export.Parse(data); err == nil {
+		err = configuration.Verify()
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+		return nil, nil, err
+	}
+	_, _ = fmt.Fprintf(progress, "Done, %s with firmware %s.\n", configuration.Model, configuration.FirmwareVersion())
+	return data, configuration, nil
+}
+
+// readExportPassword reads the export password from the source, if one is
+// given.
+func readExportPassword(source string, progress io.Writer) (string, error) {
+	if source == "" {
+		return "", nil
+	}
+	password, err := readSecret(source)
+	if err != nil {
+		_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+	}
+	return password, err
+}
+
+// decryptExport decrypts the secrets of an export with the password, or masks
+// them if no password is given.
+func decryptExport(configuration *export.Export, password string, progress io.Writer) (*export.Export, error) {
+	if password == "" {
+		return configuration.MaskSecrets(), nil
+	}
+	_, _ = fmt.Fprint(progress, "Decrypting secrets… ")
+	configuration, err := configuration.Decrypt(password)
+	if err != nil {
+		_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+		return nil, err
+	}
+	_, _ = fmt.Fprintln(progress, "Done.")
+	return configuration, nil
+}
+
+func backupInspect(command *backupInspectCommand) error {
+	progress, output := outputWriters(true)
+
+	password, err := readExportPassword(command.PasswordFrom, progress)
+	if err != nil {
+		return err
+	}
+	_, configuration, err := loadExport(command.File, progress)
+	if err != nil {
+		return err
+	}
+	if configuration, err = decryptExport(configuration, password, progress); err != nil {
+		return err
+	}
+
+	if command.Section != "" {
+		section, ok := configuration.Section(command.Section)
+		if !ok {
+			err = fmt.Errorf("export contains no section %s", command.Section)
+			_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+			return err
+		}
+		_, err = output.Write(section.Content)
+		return err
+	}
+
+	_, _ = fmt.Fprintf(output, "Model: %s\n", configuration.Model)
+	for _, variable := range configuration.Variables {
+		if variable.Name != "Password" {
+			_, _ = fmt.Fprintf(output, "%s: %s\n", variable.Name, variable.Value)
+		}
+	}
+	_, _ = fmt.Fprintf(output, "Checksum: %08X\n", configuration.Checksum)
+	_, _ = fmt.Fprintln(output)
+	for _, section := range configuration.Sections {
+		_, _ = fmt.Fprintf(output, "%-15s %8d bytes  %s\n", section.Type, len(section.Content), section.Name)
+	}
+	return nil
+}
+
+// backupDiff compares two exports and, like diff, exits with status 1 if they
+// differ.
+func backupDiff(command *backupDiffCommand) error {
+	progress, output := outputWriters(true)
+
+	oldPassword, err := readExportPassword(command.PasswordFrom, progress)
+	if err != nil {
+		return err
+	}
+	newPassword := oldPassword
+	if command.NewPasswordFrom != "" {
+		if newPassword, err = readExportPassword(command.NewPasswordFrom, progress); err != nil {
+			return err
+		}
+	}
+
+	_, old, err := loadExport(command.Old, progress)
+	if err != nil {
+		return err
+	}
+	_, current, err := loadExport(command.New, progress)
+	if err != nil {
+		return err
+	}
+	if old, err = decryptExport(old, oldPassword, progress); err != nil {
+		return err
+	}
+	if current, err = decryptExport(current, newPassword, progress); err != nil {
+		return err
+	}
+
+	changes := export.Diff(old, current)
+	for _, change := range changes {
+		switch {
+		case !change.Section && change.Kind == export.Modified:
+			_, _ = fmt.Fprintf(output, "~ %s: %s -> %s\n", change.Name, change.Old, change.New)
+		case !change.Section:
+			_, _ = fmt.Fprintf(output, "%s %s: %s%s\n", changeSymbols[change.Kind], change.Name, change.Old, change.New)
+		case change.Large:
+			_, _ = fmt.Fprintf(output, "~ %s (too many changed lines to list)\n", change.Name)
+		case change.Kind == export.Modified && change.Lines == nil:
+			_, _ = fmt.Fprintf(output, "~ %s (binary)\n", change.Name)
+		default:
+			_, _ = fmt.Fprintf(output, "%s %s\n", changeSymbols[change.Kind], change.Name)
+			for _, line := range change.Lines {
+				_, _ = fmt.Fprintf(output, "  %c %s\n", line.Op, line.Line)
+			}
+		}
+	}
+	if len(changes) > 0 {
+		return exitStatus(1)
+	}
+	return nil
+}
+
+var changeSymbols = map[export.ChangeKind]string{
+	export.Added:    "+",
+	export.Removed:  "-",
+	export.Modified: "~",
+}
@@ -1,57 +1,207 @@
 package main
 
 import (
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"fritzbox-client/api"
-	"io"
 	"os"
+	"time"
 )
 
 type certCommand struct {
-	KeyPath         string `arg:"positional,required" placeholder:"path_key"`
-	CertificatePath string `arg:"positional,required" placeholder:"path_cert"`
-	KeyPass         string `arg:"positional" placeholder:"pass_key"`
+	Install *certInstallCommand `arg:"subcommand:install"`
+	Show    *certShowCommand    `arg:"subcommand:show"`
+	Export  *certExportCommand  `arg:"subcommand:export"`
+	Check   *certCheckCommand   `arg:"subcommand:check"`
+	Acme    *certAcmeCommand    `arg:"subcommand:acme"`
+	Watch   *certWatchCommand   `arg:"subcommand:watch"`
+}
+
+type certInstallCommand struct {
+	KeyPath         string   `arg:"positional" placeholder:"path_key"`
+	CertificatePath string   `arg:"positional" placeholder:"path_cert"`
+	KeyPass         string   `arg:"positional" placeholder:"pass_key"`
+	Chain           []string `arg:"--chain,separate" placeholder:"path_chain"`
+	Pkcs12          string   `arg:"--pkcs12" placeholder:"path_pkcs12"`
+	Pkcs12Pass      string   `arg:"--pkcs12-pass" placeholder:"pass_pkcs12"`
+	EncryptKey      string   `arg:"--encrypt-key" placeholder:"passphrase"`
+	Domains         []string `arg:"--domain,separate" placeholder:"domain"`
+	RSAOnly         bool     `arg:"--rsa-only"`
+	SkipValidation  bool     `arg:"--skip-validation"`
+	certUploadOptions
+}
+
+// certUploadOptions are shared by all commands that upload a certificate.
+type certUploadOptions struct {
+	Force         bool          `arg:"--force"`
+	Verify        bool          `arg:"--verify"`
+	VerifyTimeout time.Duration `arg:"--verify-timeout" default:"2m" placeholder:"duration"`
+}
+
+// certificatePolicy returns the policy a certificate for the box is validated
+// with. Only the given domains are checked, as --host is usually an address in
+// the home network and the names the box is reached by from outside are
+// unknown. ECDSA keys are rejected if the firmware of the box, which is
+// detected without login, does not support them.
+func certificatePolicy(options args, domains []string, rsaOnly bool) api.CertificatePolicy {
+	policy := api.DefaultCertificatePolicy()
+	policy.Hostnames = domains
+	policy.AllowECDSA = !rsaOnly
+	if policy.AllowECDSA {
+		if client, err := api.NewClient(options.Hostname); err == nil {
+			if _, err = client.Detect(); err == nil {
+				policy.AllowECDSA = client.Supports(api.CapabilityECDSACertificates)
+			}
+		}
+	}
+	return policy
+}
+
+func (c *certCommand) task() string {
+	switch {
+	case c.Install != nil:
+		return "install"
+	case c.Show != nil:
+		return "show"
+	case c.Export != nil:
+		return "export"
+	case c.Check != nil:
+		return "check"
+	case c.Acme != nil:
+		return "acme"
+	case c.Watch != nil:
+		return "watch"
+	default:
+		return ""
+	}
 }
 
 func commandCert(options args) error {
+	switch options.Cert.task() {
+	case "install":
+		return certInstall(options, options.Cert.Install)
+	case "show":
+		return certShow(options, options.Cert.Show)
+	case "export":
+		return certExport(options, options.Cert.Export)
+	case "check":
+		return certCheck(options, options.Cert.Check)
+	case "acme":
+		return certAcme(options, options.Cert.Acme)
+	case "watch":
+		return certWatch(options, options.Cert.Watch)
+	}
+	return nil
+}
+
+func certInstall(options args, install *certInstallCommand) error {
 	var err error
 
-	var client api.FritzboxClient
-	if client, err = api.NewClient(options.Hostname); err != nil {
+	paths := []string{install.CertificatePath, install.KeyPath}
+	password := install.KeyPass
+	if install.Pkcs12 != "" {
+		paths = []string{install.Pkcs12}
+		password = install.Pkcs12Pass
+	} else if install.KeyPath == "" || install.CertificatePath == "" {
+		err = errors.New("either path_key and path_cert or --pkcs12 are required")
+		fmt.Printf("Error: %s\n", err.Error())
 		return err
 	}
+	paths = append(paths, install.Chain...)
+
+	var inputs [][]byte
+	for _, path := range paths {
+		fmt.Printf("Loading %s… ", path)
+		var data []byte
+		if data, err = os.ReadFile(path); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		inputs = append(inputs, data)
+		fmt.Println("Done.")
+	}
 
-	fmt.Printf("Logging in to %s as %s… ", options.Hostname, options.Username)
-	var sessionInfo api.SessionInfo
-	if sessionInfo, err = client.Login(options.Username, options.Password); err != nil {
+	fmt.Print("Processing certificate… ")
+	var bundle api.CertificateBundle
+	if bundle, err = api.LoadCertificateBundle(inputs, password); err != nil {
 		fmt.Printf("Error: %s\n", err.Error())
 		return err
 	}
-	fmt.Println("Done.")
+	if install.EncryptKey != "" {
+		if bundle.Key == nil {
+			err = errors.New("the private key cannot be decrypted locally and cannot be re-encrypted")
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		bundle.Passphrase = install.EncryptKey
+	}
+	fmt.Printf("Found %d certificates.\n", len(bundle.Chain))
+	for _, stripped := range bundle.Stripped {
+		fmt.Printf("Stripped unsupported PEM block %s.\n", stripped)
+	}
 
-	fmt.Printf("Loading certificate from %s… ", options.Cert.CertificatePath)
-	var certificate io.ReadCloser
-	if certificate, err = os.Open(options.Cert.CertificatePath); err != nil {
-		fmt.Printf("Error: %s\n", err.Error())
+	if !install.SkipValidation {
+		fmt.Print("Validating certificate… ")
+		if err = bundle.Validate(certificatePolicy(options, install.Domains, install.RSAOnly)); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		fmt.Println("Done.")
+	}
+
+	return uploadCertificateBundle(options, bundle, install.certUploadOptions)
+}
+
+// uploadCertificateBundle uploads a certificate bundle to the box, unless the
+// box already serves its leaf certificate.
+func uploadCertificateBundle(options args, bundle api.CertificateBundle, upload certUploadOptions) error {
+	var err error
+
+	leaf := bundle.Leaf()
+
+	var client api.FritzboxClient
+	if client, err = api.NewClient(options.Hostname); err != nil {
 		return err
 	}
-	fmt.Println("Done.")
 
-	fmt.Printf("Loading key from %s… ", options.Cert.KeyPath)
-	var key io.ReadCloser
-	if key, err = os.Open(options.Cert.KeyPath); err != nil {
-		fmt.Printf("Error: %s\n", err.Error())
+	if !upload.Force {
+		fmt.Print("Checking certificate served by the box… ")
+		var served []*x509.Certificate
+		if served, err = client.ServedCertificates(); err != nil {
+			fmt.Printf("Failed: %s, uploading anyway.\n", err.Error())
+		} else if api.CertificateFingerprint(served[0]) == api.CertificateFingerprint(leaf) {
+			fmt.Println("Done.")
+			fmt.Printf("Skipped: certificate %s is already installed.\n", api.CertificateFingerprint(leaf))
+			return nil
+		} else {
+			fmt.Println("Done.")
+		}
+	}
+
+	client, sessionInfo, err := login(options)
+	if err != nil {
 		return err
 	}
-	fmt.Println("Done.")
 
 	fmt.Printf("Updating TLS certificate… ")
-	var message string
-	if message, err = client.UpdateTLSCertificate(sessionInfo.Sid, options.Cert.KeyPass, []io.ReadCloser{certificate, key}); err != nil {
+	var result api.CertificateUpdateResult
+	updateOptions := api.CertificateUpdateOptions{
+		Verify:   upload.Verify,
+		Expected: bundle.Chain,
+		Timeout:  upload.VerifyTimeout,
+	}
+	if result, err = client.UpdateTLSCertificate(sessionInfo.Sid, bundle, updateOptions); err != nil {
 		fmt.Printf("Error: %s\n", err.Error())
 		return err
 	}
-	fmt.Printf("Done: %s\n", message)
+	fmt.Printf("Done: %s\n", result.Message)
+	if result.Verified {
+		if result.OldFingerprint != "" {
+			fmt.Printf("Old certificate: %s, expires %s\n", result.OldFingerprint, result.OldExpiry.Format(time.RFC3339))
+		}
+		fmt.Printf("New certificate: %s, expires %s\n", result.NewFingerprint, result.NewExpiry.Format(time.RFC3339))
+	}
 
 	return nil
 }
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"fritzbox-client/acme"
+	"fritzbox-client/api"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+type certAcmeCommand struct {
+	Domains            []string        `arg:"--domain,separate,required" placeholder:"domain"`
+	Directory          string          `arg:"--directory" placeholder:"url"`
+	Email              string          `arg:"--email" placeholder:"email"`
+	EabKeyID           string          `arg:"--eab-kid" placeholder:"kid"`
+	EabHmacKey         string          `arg:"--eab-hmac" placeholder:"base64url"`
+	CACert             string          `arg:"--ca-cert" placeholder:"path_ca"`
+	StateDir           string          `arg:"--state-dir" placeholder:"dir"`
+	KeyType            string          `arg:"--key-type" default:"rsa2048" placeholder:"<rsa2048|rsa3072|rsa4096|ec256|ec384>"`
+	RenewBefore        expiryThreshold `arg:"--renew-before" default:"30d" placeholder:"threshold"`
+	Renew              bool            `arg:"--renew"`
+	DNSProvider        string          `arg:"--dns-provider,required" placeholder:"<rfc2136|exec>"`
+	ExecHook           string          `arg:"--exec-hook" placeholder:"command"`
+	RFC2136Server      string          `arg:"--rfc2136-server" placeholder:"host:port"`
+	RFC2136Zone        string          `arg:"--rfc2136-zone" placeholder:"zone"`
+	TSIGName           string          `arg:"--tsig-name" placeholder:"name"`
+	TSIGSecret         string          `arg:"--tsig-secret" placeholder:"secret"`
+	TSIGAlgorithm      string          `arg:"--tsig-algorithm" default:"hmac-sha256." placeholder:"algorithm"`
+	DNSResolver        string          `arg:"--dns-resolver" placeholder:"host:port"`
+	PropagationTimeout time.Duration   `arg:"--propagation-timeout" default:"2m" placeholder:"duration"`
+	certUploadOptions
+}
+
+func (c *certAcmeCommand) provider() (acme.DNSProvider, error) {
+	switch c.DNSProvider {
+	case "exec":
+		if c.ExecHook == "" {
+			return nil, errors.New("--dns-provider exec requires --exec-hook")
+		}
+		return acme.ExecProvider{Command: c.ExecHook}, nil
+	case "rfc2136":
+		if c.RFC2136Server == "" || c.RFC2136Zone == "" {
+			return nil, errors.New("--dns-provider rfc2136 requires --rfc2136-server and --rfc2136-zone")
+		}
+		return acme.RFC2136Provider{
+			Server:        c.RFC2136Server,
+			Zone:          c.RFC2136Zone,
+			TSIGName:      c.TSIGName,
+			TSIGSecret:    c.TSIGSecret,
+			TSIGAlgorithm: c.TSIGAlgorithm,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown dns provider %q", c.DNSProvider)
+	}
+}
+
+func (c *certAcmeCommand) config() (acme.Config, error) {
+	config := acme.Config{
+		DirectoryURL:       c.Directory,
+		Email:              c.Email,
+		EABKeyID:           c.EabKeyID,
+		StateDir:           c.StateDir,
+		KeyType:            c.KeyType,
+		Resolver:           c.DNSResolver,
+		PropagationTimeout: c.PropagationTimeout,
+	}
+	var err error
+	if config.Provider, err = c.provider(); err != nil {
+		return config, err
+	}
+	if c.EabKeyID != "" {
+		if config.EABHMACKey, err = base64.RawURLEncoding.DecodeString(c.EabHmacKey); err != nil {
+			return config, fmt.Errorf("invalid --eab-hmac: %w", err)
+		}
+	}
+	if config.DirectoryURL == "" {
+		config.DirectoryURL = acme.LetsEncryptURL
+	}
+	if config.StateDir == "" {
+		var configDir string
+		if configDir, err = os.UserConfigDir(); err != nil {
+			return config, err
+		}
+		config.StateDir = filepath.Join(configDir, "fritzbox-client", "acme")
+	}
+	if c.CACert != "" {
+		var data []byte
+		if data, err = os.ReadFile(c.CACert); err != nil {
+			return config, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return config, fmt.Errorf("no certificates found in %s", c.CACert)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		config.HTTPClient = &http.Client{Transport: transport}
+	}
+	return config, nil
+}
+
+// storedCertificateValid reports whether the stored certificate covers all
+// domains and is valid for longer than the renewal threshold.
+func storedCertificateValid(bundle api.CertificateBundle, domains []string, renewBefore time.Duration) bool {
+	if bundle.Validate(api.DefaultCertificatePolicy()) != nil {
+		return false
+	}
+	leaf := bundle.Leaf()
+	for _, domain := range domains {
+		if !slices.Contains(leaf.DNSNames, domain) {
+			return false
+		}
+	}
+	return time.Until(leaf.NotAfter) > renewBefore
+}
+
+func certAcme(options args, command *certAcmeCommand) error {
+	config, err := command.config()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	policy := certificatePolicy(options, command.Domains, false)
+	if !policy.AllowECDSA && strings.HasPrefix(config.KeyType, "ec") {
+		err = errors.New("the firmware of the box does not support ECDSA keys, use --key-type rsa2048")
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+
+	var client *acme.Client
+	if client, err = acme.NewClient(config); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+
+	var bundle api.CertificateBundle
+	renew := true
+	fmt.Print("Loading stored certificate… ")
+	certificate, key, err := client.Load(command.Domains)
+	if err == nil {
+		bundle, err = api.LoadCertificateBundle([][]byte{certificate, key}, "")
+	}
+	switch {
+	case err != nil:
+		fmt.Printf("None: %s\n", err.Error())
+	case command.Renew:
+		fmt.Println("Done, renewal forced.")
+	case storedCertificateValid(bundle, command.Domains, time.Duration(command.RenewBefore)):
+		fmt.Printf("Done, valid until %s.\n", bundle.Leaf().NotAfter.Format(time.RFC3339))
+		renew = false
+	default:
+		fmt.Printf("Done, renewal due as it expires %s.\n", bundle.Leaf().NotAfter.Format(time.RFC3339))
+	}
+
+	if renew {
+		fmt.Printf("Obtaining certificate for %s from %s… ", strings.Join(command.Domains, ", "), config.DirectoryURL)
+		if certificate, key, err = client.Obtain(context.Background(), command.Domains); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		if bundle, err = api.LoadCertificateBundle([][]byte{certificate, key}, ""); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		fmt.Printf("Done, valid until %s.\n", bundle.Leaf().NotAfter.Format(time.RFC3339))
+	}
+
+	fmt.Print("Validating certificate… ")
+	if err = bundle.Validate(policy); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Println("Done.")
+
+	return uploadCertificateBundle(options, bundle, command.certUploadOptions)
+}
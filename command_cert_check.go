@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"fritzbox-client/api"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exit codes of Nagios/Icinga plugins.
+const (
+	nagiosOk       exitStatus = 0
+	nagiosWarning  exitStatus = 1
+	nagiosCritical exitStatus = 2
+	nagiosUnknown  exitStatus = 3
+)
+
+var nagiosStatusNames = map[exitStatus]string{
+	nagiosOk:       "OK",
+	nagiosWarning:  "WARNING",
+	nagiosCritical: "CRITICAL",
+	nagiosUnknown:  "UNKNOWN",
+}
+
+// nagiosSeverity orders the states for the overall result: UNKNOWN only wins
+// over OK, as a certificate problem on another box is more important.
+var nagiosSeverity = map[exitStatus]int{
+	nagiosOk:       0,
+	nagiosUnknown:  1,
+	nagiosWarning:  2,
+	nagiosCritical: 3,
+}
+
+// expiryThreshold is a duration that additionally accepts days, e.g. "30d".
+type expiryThreshold time.Duration
+
+func (t *expiryThreshold) UnmarshalText(text []byte) error {
+	value := string(text)
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(value, "d"), 64)
+		if err != nil {
+			return fmt.Errorf("invalid threshold %q", value)
+		}
+		*t = expiryThreshold(days * float64(24*time.Hour))
+		return nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid threshold %q", value)
+	}
+	*t = expiryThreshold(duration)
+	return nil
+}
+
+type certCheckCommand struct {
+	Hosts    []string        `arg:"positional" placeholder:"host"`
+	Warning  expiryThreshold `arg:"--warn" default:"30d" placeholder:"threshold"`
+	Critical expiryThreshold `arg:"--crit" default:"7d" placeholder:"threshold"`
+}
+
+type certCheckResult struct {
+	host      string
+	status    exitStatus
+	message   string
+	remaining time.Duration
+	checked   bool
+}
+
+// checkUrl turns a host as given to the plugin, usually without scheme like
+// box.example.com or box.example.com:8443, into the URL of the box.
+func checkUrl(host string) (string, error) {
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	parsedUrl, err := url.Parse(host)
+	if err != nil {
+		return "", err
+	}
+	if parsedUrl.Hostname() == "" {
+		return "", errors.New("no host name given")
+	}
+	return parsedUrl.String(), nil
+}
+
+func checkCertificate(host string, warning time.Duration, critical time.Duration) certCheckResult {
+	result := certCheckResult{host: host, status: nagiosUnknown}
+	boxUrl, err := checkUrl(host)
+	if err != nil {
+		result.message = fmt.Sprintf("%s: %s", host, err.Error())
+		return result
+	}
+	client, err := api.NewClient(boxUrl)
+	if err != nil {
+		result.message = fmt.Sprintf("%s: %s", host, err.Error())
+		return result
+	}
+	certificates, err := client.ServedCertificates()
+	if err != nil {
+		result.message = fmt.Sprintf("%s: %s", host, err.Error())
+		return result
+	}
+	leaf := certificates[0]
+	result.checked = true
+	result.remaining = time.Until(leaf.NotAfter)
+	days := int(result.remaining.Hours() / 24)
+	switch {
+	case result.remaining <= 0:
+		result.status = nagiosCritical
+		result.message = fmt.Sprintf("%s: expired on %s", host, leaf.NotAfter.Format(time.DateOnly))
+	case result.remaining <= critical:
+		result.status = nagiosCritical
+		result.message = fmt.Sprintf("%s: expires in %d days on %s", host, days, leaf.NotAfter.Format(time.DateOnly))
+	case result.remaining <= warning:
+		result.status = nagiosWarning
+		result.message = fmt.Sprintf("%s: expires in %d days on %s", host, days, leaf.NotAfter.Format(time.DateOnly))
+	default:
+		result.status = nagiosOk
+		result.message = fmt.Sprintf("%s: %d days left", host, days)
+	}
+	return result
+}
+
+// certCheck checks the served certificates of one or more boxes and reports
+// the result in the format of a Nagios/Icinga plugin, including perfdata with
+// the remaining validity in seconds. The box of --host is only checked if no
+// hosts are given.
+func certCheck(options args, check *certCheckCommand) error {
+	hosts := check.Hosts
+	if len(hosts) == 0 {
+		hosts = []string{options.Hostname}
+	}
+	warning, critical := time.Duration(check.Warning), time.Duration(check.Critical)
+
+	status := nagiosOk
+	var messages, perfdata []string
+	for _, host := range hosts {
+		result := checkCertificate(host, warning, critical)
+		if nagiosSeverity[result.status] > nagiosSeverity[status] {
+			status = result.status
+		}
+		messages = append(messages, result.message)
+		if result.checked {
+			// The value is alerted on when it falls below the thresholds,
+			// which the plugin guidelines express as ranges "N:".
+			perfdata = append(perfdata, fmt.Sprintf("'%s'=%ds;%d:;%d:;0", host, int(result.remaining.Seconds()), int(warning.Seconds()), int(critical.Seconds())))
+		}
+	}
+
+	fmt.Printf("CERT %s - %s", nagiosStatusNames[status], strings.Join(messages, "; "))
+	if len(perfdata) > 0 {
+		fmt.Printf(" | %s", strings.Join(perfdata, " "))
+	}
+	fmt.Println()
+	if status == nagiosOk {
+		return nil
+	}
+	return status
+}
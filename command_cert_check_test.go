@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckUrl(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"box.example.com", "https://box.example.com"},
+		{"box.example.com:8443", "https://box.example.com:8443"},
+		{"192.168.178.1", "https://192.168.178.1"},
+		{"[fd00::1]:8443", "https://[fd00::1]:8443"},
+		{"https://box.example.com:8443", "https://box.example.com:8443"},
+		{"http://fritz.box", "http://fritz.box"},
+		{"", ""},
+		{"https://", ""},
+		{":443", ""},
+	}
+	for _, test := range tests {
+		got, err := checkUrl(test.host)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %q", test.host, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%q: got %q, %v, want %q", test.host, got, err, test.want)
+		}
+	}
+}
+
+func TestCheckCertificateBareHost(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	result := checkCertificate(host, 30*24*time.Hour, 7*24*time.Hour)
+	if !result.checked || result.status != nagiosOk {
+		t.Errorf("%s: got %s (%s), want OK", host, nagiosStatusNames[result.status], result.message)
+	}
+
+	if result = checkCertificate(":"+server.URL[strings.LastIndex(server.URL, ":")+1:], time.Hour, time.Hour); result.checked || result.status != nagiosUnknown {
+		t.Errorf("a host without name was checked: %s", result.message)
+	}
+}
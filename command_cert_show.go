@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"fritzbox-client/api"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+type certShowCommand struct {
+	Source string `arg:"--source" default:"tls" placeholder:"<tls|download>"`
+	Json   bool   `arg:"--json"`
+}
+
+type certExportCommand struct {
+	Source string `arg:"--source" default:"tls" placeholder:"<tls|download>"`
+	Output string `arg:"-o,--output" placeholder:"file"`
+	Json   bool   `arg:"--json"`
+}
+
+type certificateInfo struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	DNSNames  []string  `json:"dns_names"`
+	IPs       []string  `json:"ip_addresses"`
+	Serial    string    `json:"serial"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	SHA256    string    `json:"sha256"`
+	SHA1      string    `json:"sha1"`
+}
+
+type certificateReport struct {
+	Host         string            `json:"host"`
+	Source       string            `json:"source"`
+	Certificates []certificateInfo `json:"certificates"`
+}
+
+// outputWriters returns the writers for progress messages and for the
+// result. Progress messages go to stderr if the result is machine-readable.
+func outputWriters(machineReadable bool) (progress io.Writer, output io.Writer) {
+	if machineReadable {
+		return os.Stderr, os.Stdout
+	}
+	return os.Stdout, os.Stdout
+}
+
+func newCertificateInfo(certificate *x509.Certificate) certificateInfo {
+	info := certificateInfo{
+		Subject:   certificate.Subject.String(),
+		Issuer:    certificate.Issuer.String(),
+		DNSNames:  certificate.DNSNames,
+		Serial:    certificate.SerialNumber.Text(16),
+		NotBefore: certificate.NotBefore,
+		NotAfter:  certificate.NotAfter,
+		SHA256:    api.CertificateFingerprint(certificate),
+		SHA1:      api.CertificateFingerprintSHA1(certificate),
+	}
+	for _, ip := range certificate.IPAddresses {
+		info.IPs = append(info.IPs, ip.String())
+	}
+	return info
+}
+
+func printCertificateInfo(writer io.Writer, info certificateInfo) {
+	sans := append(append([]string{}, info.DNSNames...), info.IPs...)
+	_, _ = fmt.Fprintf(writer, "Subject:    %s\n", info.Subject)
+	_, _ = fmt.Fprintf(writer, "Issuer:     %s\n", info.Issuer)
+	_, _ = fmt.Fprintf(writer, "SANs:       %s\n", strings.Join(sans, ", "))
+	_, _ = fmt.Fprintf(writer, "Serial:     %s\n", info.Serial)
+	_, _ = fmt.Fprintf(writer, "Not before: %s\n", info.NotBefore.Format(time.RFC3339))
+	_, _ = fmt.Fprintf(writer, "Not after:  %s (%d days left)\n", info.NotAfter.Format(time.RFC3339), int(time.Until(info.NotAfter).Hours()/24))
+	_, _ = fmt.Fprintf(writer, "SHA-256:    %s\n", info.SHA256)
+	_, _ = fmt.Fprintf(writer, "SHA-1:      %s\n", info.SHA1)
+}
+
+// fetchInstalledCertificates retrieves the certificate chain of the box,
+// either from a TLS handshake or from the certificate download page.
+func fetchInstalledCertificates(options args, source string, progress io.Writer) ([]*x509.Certificate, error) {
+	var err error
+	var certificates []*x509.Certificate
+	switch source {
+	case "tls":
+		var client api.FritzboxClient
+		if client, err = api.NewClient(options.Hostname); err != nil {
+			return nil, err
+		}
+		_, _ = fmt.Fprintf(progress, "Retrieving certificate served by %s… ", options.Hostname)
+		if certificates, err = client.ServedCertificates(); err != nil {
+			_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+			return nil, err
+		}
+		_, _ = fmt.Fprintln(progress, "Done.")
+	case "download":
+		client, sessionInfo, err := loginReporting(options, progress)
+		if err != nil {
+			return nil, err
+		}
+		_, _ = fmt.Fprint(progress, "Downloading certificate… ")
+		if certificates, err = client.DownloadTLSCertificate(sessionInfo.Sid); err != nil {
+			_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+			return nil, err
+		}
+		_, _ = fmt.Fprintln(progress, "Done.")
+	default:
+		err = fmt.Errorf("unknown certificate source %q", source)
+		_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+		return nil, err
+	}
+	return certificates, nil
+}
+
+func newCertificateReport(options args, source string, certificates []*x509.Certificate) certificateReport {
+	report := certificateReport{Host: options.Hostname, Source: source}
+	for _, certificate := range certificates {
+		report.Certificates = append(report.Certificates, newCertificateInfo(certificate))
+	}
+	return report
+}
+
+func certShow(options args, show *certShowCommand) error {
+	progress, output := outputWriters(show.Json)
+
+	certificates, err := fetchInstalledCertificates(options, show.Source, progress)
+	if err != nil {
+		return err
+	}
+
+	report := newCertificateReport(options, show.Source, certificates)
+	if show.Json {
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	for i, info := range report.Certificates {
+		_, _ = fmt.Fprintln(output)
+		if i == 0 {
+			_, _ = fmt.Fprintln(output, "Certificate:")
+		} else {
+			_, _ = fmt.Fprintln(output, "Issued by:")
+		}
+		printCertificateInfo(output, info)
+	}
+	return nil
+}
+
+func certExport(options args, export *certExportCommand) error {
+	progress, output := outputWriters(export.Output == "" || export.Json)
+	if export.Json && export.Output == "" {
+		err := errors.New("--json requires --output")
+		_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+		return err
+	}
+
+	certificates, err := fetchInstalledCertificates(options, export.Source, progress)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	for _, certificate := range certificates {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})...)
+	}
+	if export.Output == "" {
+		_, err = output.Write(data)
+		return err
+	}
+
+	_, _ = fmt.Fprintf(progress, "Writing certificate to %s… ", export.Output)
+	if err = os.WriteFile(export.Output, data, 0644); err != nil {
+		_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+		return err
+	}
+	_, _ = fmt.Fprintln(progress, "Done.")
+
+	if export.Json {
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(newCertificateReport(options, export.Source, certificates))
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"fritzbox-client/api"
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+)
+
+type certWatchCommand struct {
+	KeyPath         string        `arg:"positional,required" placeholder:"path_key"`
+	CertificatePath string        `arg:"positional,required" placeholder:"path_cert"`
+	KeyPass         string        `arg:"--key-pass" placeholder:"pass_key"`
+	Chain           []string      `arg:"--chain,separate" placeholder:"path_chain"`
+	Domains         []string      `arg:"--domain,separate" placeholder:"domain"`
+	RSAOnly         bool          `arg:"--rsa-only"`
+	Debounce        time.Duration `arg:"--debounce" default:"5s" placeholder:"duration"`
+	PollInterval    time.Duration `arg:"--poll-interval" default:"1m" placeholder:"duration"`
+	certUploadOptions
+}
+
+// certWatcher deploys the watched certificate files whenever their content
+// changes.
+type certWatcher struct {
+	options  args
+	command  *certWatchCommand
+	paths    []string
+	lastHash string
+}
+
+// read returns the content of all watched files and a hash over it.
+func (w *certWatcher) read() ([][]byte, string, error) {
+	hash := sha256.New()
+	var inputs [][]byte
+	for _, path := range w.paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, "", err
+		}
+		_, _ = fmt.Fprintf(hash, "%s:%d:", path, len(data))
+		hash.Write(data)
+		inputs = append(inputs, data)
+	}
+	return inputs, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// deploy uploads the certificate if the files changed since the last attempt.
+// Invalid files are not retried until they change again, failed uploads are
+// retried on the next poll.
+func (w *certWatcher) deploy() {
+	inputs, hash, err := w.read()
+	if err != nil {
+		fmt.Printf("%s Error: %s\n", time.Now().Format(time.RFC3339), err.Error())
+		return
+	}
+	if hash == w.lastHash {
+		return
+	}
+
+	fmt.Printf("%s Processing certificate… ", time.Now().Format(time.RFC3339))
+	bundle, err := api.LoadCertificateBundle(inputs, w.command.KeyPass)
+	if err == nil {
+		err = bundle.Validate(certificatePolicy(w.options, w.command.Domains, w.command.RSAOnly))
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		w.lastHash = hash
+		return
+	}
+	fmt.Printf("Done, certificate %s.\n", api.CertificateFingerprint(bundle.Leaf()))
+
+	if err = uploadCertificateBundle(w.options, bundle, w.command.certUploadOptions); err != nil {
+		return
+	}
+	w.lastHash = hash
+}
+
+// certWatch watches the certificate files and uploads them whenever their
+// content changes, until it is interrupted. Changes are picked up from file
+// system events and, as fallback for network file systems, by polling.
+func certWatch(options args, command *certWatchCommand) error {
+	watcher := &certWatcher{
+		options: options,
+		command: command,
+		paths:   []string{filepath.Clean(command.CertificatePath), filepath.Clean(command.KeyPath)},
+	}
+	for _, path := range command.Chain {
+		watcher.paths = append(watcher.paths, filepath.Clean(path))
+	}
+
+	// Watch the directories instead of the files, as certificates are usually
+	// replaced by renaming new files over the old ones.
+	var events chan fsnotify.Event
+	var watchErrors chan error
+	notify, err := fsnotify.NewWatcher()
+	if err == nil {
+		defer notify.Close()
+		for _, path := range watcher.paths {
+			if err = notify.Add(filepath.Dir(path)); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			events, watchErrors = notify.Events, notify.Errors
+		}
+	}
+	if err != nil {
+		fmt.Printf("Unable to watch for file changes, polling every %s: %s\n", command.PollInterval, err.Error())
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	poll := time.NewTicker(command.PollInterval)
+	defer poll.Stop()
+	debounce := time.NewTimer(0)
+	defer debounce.Stop()
+
+	fmt.Printf("Watching %d files for changes.\n", len(watcher.paths))
+	for {
+		select {
+		case event := <-events:
+			if slices.Contains(watcher.paths, filepath.Clean(event.Name)) {
+				debounce.Reset(command.Debounce)
+			}
+		case err = <-watchErrors:
+			fmt.Printf("%s Error: %s\n", time.Now().Format(time.RFC3339), err.Error())
+		case <-poll.C:
+			watcher.deploy()
+		case <-debounce.C:
+			watcher.deploy()
+		case <-signals:
+			fmt.Println("Stopped watching.")
+			return nil
+		}
+	}
+}
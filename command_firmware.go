@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"fritzbox-client/api"
+	"os"
+	"time"
+)
+
+type firmwareCommand struct {
+	Upload *firmwareUploadCommand `arg:"subcommand:upload"`
+	Check  *firmwareCheckCommand  `arg:"subcommand:check"`
+	Update *firmwareUpdateCommand `arg:"subcommand:update"`
+}
+
+// firmwareWaitOptions are shared by the commands that install a firmware.
+type firmwareWaitOptions struct {
+	NoWait  bool          `arg:"--no-wait"`
+	Timeout time.Duration `arg:"--timeout" default:"10m" placeholder:"duration"`
+}
+
+func (o firmwareWaitOptions) updateOptions() api.FirmwareUpdateOptions {
+	return api.FirmwareUpdateOptions{Wait: !o.NoWait, Timeout: o.Timeout}
+}
+
+type firmwareUploadCommand struct {
+	Image          string `arg:"positional,required" placeholder:"image"`
+	SkipModelCheck bool   `arg:"--skip-model-check"`
+	firmwareWaitOptions
+}
+
+type firmwareCheckCommand struct{}
+
+type firmwareUpdateCommand struct {
+	firmwareWaitOptions
+}
+
+func (c *firmwareCommand) task() string {
+	switch {
+	case c.Upload != nil:
+		return "upload"
+	case c.Check != nil:
+		return "check"
+	case c.Update != nil:
+		return "update"
+	default:
+		return ""
+	}
+}
+
+func commandFirmware(options args) error {
+	switch options.Firmware.task() {
+	case "upload":
+		return firmwareUpload(options, options.Firmware.Upload)
+	case "check":
+		return firmwareCheck(options)
+	case "update":
+		return firmwareUpdate(options, options.Firmware.Update)
+	}
+	return nil
+}
+
+func printFirmwareUpdateResult(result api.FirmwareUpdateResult, wait bool) error {
+	var err error
+	switch {
+	case !wait:
+		fmt.Println("The box installs the firmware and restarts.")
+		return nil
+	case !result.Ready.Restarted:
+		err = errors.New("the box did not restart")
+	case result.OldVersion == result.NewVersion:
+		err = fmt.Errorf("the box still runs firmware %s", result.NewVersion)
+	default:
+		fmt.Printf("Firmware updated from %s to %s.\n", result.OldVersion, result.NewVersion)
+		printReadyReport(os.Stdout, result.Ready, false)
+		return nil
+	}
+	fmt.Printf("Error: %s\n", err.Error())
+	return err
+}
+
+func firmwareUpload(options args, command *firmwareUploadCommand) error {
+	var err error
+
+	fmt.Printf("Checking %s… ", command.Image)
+	var file *os.File
+	if file, err = os.Open(command.Image); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	image, err := api.ReadFirmwareImage(file)
+	_ = file.Close()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Println("Done.")
+
+	var client api.FritzboxClient
+	if client, err = api.NewClient(options.Hostname); err != nil {
+		return err
+	}
+	if !command.SkipModelCheck {
+		fmt.Print("Checking the image matches the box… ")
+		var info api.BoxInfo
+		if info, err = client.GetBoxInfo(); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		if len(image.HWRevisions) == 0 {
+			err = errors.New("the image does not check the hardware revision, use --skip-model-check if it is meant for the box")
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		if !image.Supports(info.HWRevision) {
+			err = fmt.Errorf("the image is for hardware revision %v, the %s has revision %s", image.HWRevisions, info.Name, info.HWRevision)
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		fmt.Printf("Done, %s running %s.\n", info.Name, info.Version)
+	}
+
+	client, sessionInfo, err := login(options)
+	if err != nil {
+		return err
+	}
+
+	if command.NoWait {
+		fmt.Print("Uploading firmware… ")
+	} else {
+		fmt.Print("Uploading firmware and waiting for the box to install it and restart… ")
+	}
+	if file, err = os.Open(command.Image); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	var result api.FirmwareUpdateResult
+	if result, err = client.UploadFirmware(sessionInfo.Sid, file, command.updateOptions()); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Printf("Done: %s\n", result.Message)
+	return printFirmwareUpdateResult(result, !command.NoWait)
+}
+
+func firmwareCheck(options args) error {
+	client, _, err := login(options)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print("Checking for firmware updates… ")
+	var info api.FirmwareUpdateInfo
+	if info, err = client.CheckFirmwareUpdate(); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Println("Done.")
+
+	var boxInfo api.BoxInfo
+	if boxInfo, err = client.GetBoxInfo(); err == nil {
+		fmt.Printf("Installed version: %s\n", boxInfo.Version)
+	}
+	if !info.Available {
+		fmt.Println("No update available.")
+		return nil
+	}
+	fmt.Printf("Available version: %s\n", info.Version)
+	if info.InfoURL != "" {
+		fmt.Printf("Release notes:     %s\n", info.InfoURL)
+	}
+	return nil
+}
+
+func firmwareUpdate(options args, command *firmwareUpdateCommand) error {
+	client, _, err := login(options)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print("Checking for firmware updates… ")
+	var info api.FirmwareUpdateInfo
+	if info, err = client.CheckFirmwareUpdate(); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	if !info.Available {
+		fmt.Println("Done, no update available.")
+		return nil
+	}
+	fmt.Printf("Done, version %s available.\n", info.Version)
+
+	if command.NoWait {
+		fmt.Print("Starting update… ")
+	} else {
+		fmt.Print("Updating firmware and waiting for the box to restart… ")
+	}
+	var result api.FirmwareUpdateResult
+	if result, err = client.UpdateFirmware(command.updateOptions()); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Println("Done.")
+	return printFirmwareUpdateResult(result, !command.NoWait)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"fritzbox-client/api"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type hostsCommand struct {
+	List  *hostsListCommand  `arg:"subcommand:list"`
+	Set   *hostsSetCommand   `arg:"subcommand:set"`
+	Apply *hostsApplyCommand `arg:"subcommand:apply"`
+}
+
+type hostsListCommand struct {
+	Format string `arg:"--format" default:"table" placeholder:"<table|json|csv>"`
+	Active bool   `arg:"--active"`
+}
+
+// hostInfo is the output form of api.LanDevice.
+type hostInfo struct {
+	Name      string     `json:"name"`
+	MAC       string     `json:"mac"`
+	IPv4      string     `json:"ipv4"`
+	IPv6      []string   `json:"ipv6"`
+	Interface string     `json:"interface"`
+	Active    bool       `json:"active"`
+	Speed     int        `json:"speed"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+}
+
+func newHostInfo(device api.LanDevice) hostInfo {
+	info := hostInfo{
+		Name:      device.Name,
+		MAC:       device.MAC,
+		IPv4:      device.IPv4,
+		IPv6:      device.IPv6,
+		Interface: device.Interface,
+		Active:    device.Active,
+		Speed:     device.Speed,
+	}
+	if info.IPv6 == nil {
+		info.IPv6 = []string{}
+	}
+	if !device.LastSeen.IsZero() {
+		info.LastSeen = &device.LastSeen
+	}
+	return info
+}
+
+func (c *hostsCommand) task() string {
+	switch {
+	case c.List != nil:
+		return "list"
+	case c.Set != nil:
+		return "set"
+	case c.Apply != nil:
+		return "apply"
+	default:
+		return ""
+	}
+}
+
+func commandHosts(options args) error {
+	switch options.Hosts.task() {
+	case "list":
+		return hostsList(options, options.Hosts.List)
+	case "set":
+		return hostsSet(options, options.Hosts.Set)
+	case "apply":
+		return hostsApply(options, options.Hosts.Apply)
+	}
+	return nil
+}
+
+func hostsList(options args, command *hostsListCommand) error {
+	progress, output := outputWriters(command.Format != "table")
+	switch command.Format {
+	case "table", "json", "csv":
+	default:
+		err := fmt.Errorf("unknown format %q, expected table, json or csv", command.Format)
+		_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+		return err
+	}
+
+	client, sessionInfo, err := loginReporting(options, progress)
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprint(progress, "Querying list of network devices… ")
+	devices, warning, err := client.ListHosts(sessionInfo.Sid)
+	if warning != nil {
+		_, _ = fmt.Fprintf(progress, "Warning: %s. ", warning.Error())
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+		return err
+	}
+	_, _ = fmt.Fprintf(progress, "Found %d devices.\n", len(devices))
+
+	hosts := make([]hostInfo, 0, len(devices))
+	for _, device := range devices {
+		if command.Active && !device.Active {
+			continue
+		}
+		hosts = append(hosts, newHostInfo(device))
+	}
+
+	switch command.Format {
+	case "json":
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(hosts)
+	case "csv":
+		return writeHostsCSV(output, hosts)
+	}
+	return writeHostsTable(output, hosts)
+}
+
+func formatLastSeen(lastSeen *time.Time) string {
+	if lastSeen == nil {
+		return ""
+	}
+	return lastSeen.Format(time.RFC3339)
+}
+
+func writeHostsCSV(output io.Writer, hosts []hostInfo) error {
+	writer := csv.NewWriter(output)
+	_ = writer.Write([]string{"name", "mac", "ipv4", "ipv6", "interface", "active", "speed", "last_seen"})
+	for _, host := range hosts {
+		_ = writer.Write([]string{
+			host.Name,
+			host.MAC,
+			host.IPv4,
+			strings.Join(host.IPv6, " "),
+			host.Interface,
+			strconv.FormatBool(host.Active),
+			strconv.Itoa(host.Speed),
+			formatLastSeen(host.LastSeen),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeHostsTable(output io.Writer, hosts []hostInfo) error {
+	writer := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "NAME\tMAC\tIPV4\tIPV6\tINTERFACE\tACTIVE\tSPEED\tLAST SEEN")
+	for _, host := range hosts {
+		active := "no"
+		if host.Active {
+			active = "yes"
+		}
+		speed := ""
+		if host.Speed > 0 {
+			speed = fmt.Sprintf("%d Mbit/s", host.Speed)
+		}
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			host.Name, host.MAC, host.IPv4, strings.Join(host.IPv6, " "), host.Interface, active, speed, formatLastSeen(host.LastSeen))
+	}
+	return writer.Flush()
+}
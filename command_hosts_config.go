@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"fritzbox-client/api"
+	"gopkg.in/yaml.v3"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+type hostsSetCommand struct {
+	MAC string `arg:"positional,required" placeholder:"mac"`
+	hostSettings
+	DryRun bool `arg:"--dry-run"`
+}
+
+type hostsApplyCommand struct {
+	File   string `arg:"-f,--file,required" placeholder:"file"`
+	DryRun bool   `arg:"--dry-run"`
+}
+
+// hostSettings are the settings of a network device managed by hosts set and
+// hosts apply. Unset fields are left unchanged.
+type hostSettings struct {
+	Name     *string `arg:"--name" placeholder:"name" yaml:"name,omitempty"`
+	IP       *string `arg:"--ip" placeholder:"ipv4" yaml:"ip,omitempty"`
+	StaticIP *bool   `arg:"--static-ip" placeholder:"bool" yaml:"static_ip,omitempty"`
+	Profile  *string `arg:"--profile" placeholder:"profile" yaml:"profile,omitempty"`
+}
+
+type hostConfig struct {
+	MAC          string `yaml:"mac"`
+	hostSettings `yaml:",inline"`
+}
+
+type hostsConfig struct {
+	Devices []hostConfig `yaml:"devices"`
+}
+
+// mergeHostSettings copies the settings from wanted into current and
+// describes every change it made.
+func mergeHostSettings(current *api.LanDeviceSettings, wanted hostSettings) ([]string, error) {
+	var changes []string
+	if wanted.Name != nil && current.Name != *wanted.Name {
+		changes = append(changes, fmt.Sprintf("name: %q -> %q", current.Name, *wanted.Name))
+		current.Name = *wanted.Name
+	}
+	if wanted.IP != nil && current.IPv4 != *wanted.IP {
+		if address, err := netip.ParseAddr(*wanted.IP); err != nil || !address.Is4() {
+			return nil, fmt.Errorf("invalid IPv4 address %q", *wanted.IP)
+		}
+		changes = append(changes, fmt.Sprintf("ip: %q -> %q", current.IPv4, *wanted.IP))
+		current.IPv4 = *wanted.IP
+	}
+	if wanted.StaticIP != nil && current.StaticIP != *wanted.StaticIP {
+		changes = append(changes, fmt.Sprintf("static_ip: %t -> %t", current.StaticIP, *wanted.StaticIP))
+		current.StaticIP = *wanted.StaticIP
+	}
+	if wanted.Profile != nil {
+		profile, ok := current.ProfileByName(*wanted.Profile)
+		if !ok {
+			return nil, fmt.Errorf("unknown access profile %q", *wanted.Profile)
+		}
+		if current.Profile != profile.Id {
+			changes = append(changes, fmt.Sprintf("profile: %q -> %q", current.ProfileName(), profile.Name))
+			current.Profile = profile.Id
+		}
+	}
+	// The box only keeps the address of a device with static assignment and
+	// would otherwise hand out a different one on the next lease.
+	if wanted.IP != nil && !current.StaticIP {
+		return nil, fmt.Errorf("ip %s requires static_ip", *wanted.IP)
+	}
+	return changes, nil
+}
+
+// applyHostConfig updates the settings of the device in devices with the MAC
+// address of wanted.
+func applyHostConfig(client *api.FritzboxClient, sid api.SessionID, devices []api.LanDevice, wanted hostConfig, dryRun bool) error {
+	var device *api.LanDevice
+	for i := range devices {
+		if strings.EqualFold(devices[i].MAC, wanted.MAC) {
+			device = &devices[i]
+			break
+		}
+	}
+	if device == nil {
+		err := fmt.Errorf("%w: %s", api.ErrDeviceNotFound, wanted.MAC)
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+
+	settings, err := client.GetLanDeviceSettings(sid, device.UID)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	changes, err := mergeHostSettings(&settings, wanted.hostSettings)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	fmt.Printf("~ %s (%s)\n", device.Name, device.MAC)
+	for _, change := range changes {
+		fmt.Printf("    %s\n", change)
+	}
+	if dryRun {
+		return nil
+	}
+	fmt.Printf("Updating device %s… ", device.Name)
+	if err = client.SaveLanDeviceSettings(sid, settings); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Println("Done.")
+	return nil
+}
+
+func hostsSet(options args, command *hostsSetCommand) error {
+	client, sessionInfo, err := login(options)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print("Querying list of network devices… ")
+	var devices []api.LanDevice
+	if devices, err = client.ListLanDevices(sessionInfo.Sid); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Printf("Found %d devices.\n", len(devices))
+
+	return applyHostConfig(&client, sessionInfo.Sid, devices, hostConfig{MAC: command.MAC, hostSettings: command.hostSettings}, command.DryRun)
+}
+
+func hostsApply(options args, command *hostsApplyCommand) error {
+	fmt.Printf("Loading configuration from %s… ", command.File)
+	var config hostsConfig
+	data, err := os.ReadFile(command.File)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	if err = yaml.Unmarshal(data, &config); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	for _, device := range config.Devices {
+		if device.MAC == "" {
+			err = fmt.Errorf("device without mac in %s", command.File)
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+	}
+	fmt.Printf("Found %d devices.\n", len(config.Devices))
+
+	client, sessionInfo, err := login(options)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print("Querying list of network devices… ")
+	var devices []api.LanDevice
+	if devices, err = client.ListLanDevices(sessionInfo.Sid); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Printf("Found %d devices.\n", len(devices))
+
+	for _, wanted := range config.Devices {
+		if err = applyHostConfig(&client, sessionInfo.Sid, devices, wanted, command.DryRun); err != nil {
+			return err
+		}
+	}
+	return nil
+}
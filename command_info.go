@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"fritzbox-client/api"
+	"time"
+)
+
+type infoCommand struct {
+	Json bool `arg:"--json"`
+}
+
+// boxInformation is the combined output of jason_boxinfo.xml and, after a
+// login, the TR-064 DeviceInfo service.
+type boxInformation struct {
+	Model        string          `json:"model"`
+	HWRevision   string          `json:"hw_revision"`
+	Serial       string          `json:"serial"`
+	Firmware     string          `json:"firmware"`
+	OSVersion    string          `json:"os_version"`
+	Revision     string          `json:"revision,omitempty"`
+	Language     string          `json:"language"`
+	Country      string          `json:"country,omitempty"`
+	Uptime       *float64        `json:"uptime,omitempty"`
+	Capabilities map[string]bool `json:"capabilities"`
+}
+
+func commandInfo(options args) error {
+	command := options.Info
+	progress, output := outputWriters(command.Json)
+
+	var err error
+	var client api.FritzboxClient
+	if client, err = api.NewClient(options.Hostname); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprint(progress, "Retrieving box info… ")
+	var boxInfo api.BoxInfo
+	if boxInfo, err = client.Detect(); err != nil {
+		_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+		return err
+	}
+	_, _ = fmt.Fprintln(progress, "Done.")
+
+	info := boxInformation{
+		Model:        boxInfo.Name,
+		HWRevision:   boxInfo.HWRevision,
+		Serial:       boxInfo.Serial,
+		Firmware:     boxInfo.Version,
+		OSVersion:    client.Version().String(),
+		Revision:     boxInfo.Revision,
+		Language:     boxInfo.Language,
+		Country:      boxInfo.Country,
+		Capabilities: map[string]bool{},
+	}
+	for _, capability := range api.Capabilities {
+		info.Capabilities[string(capability)] = client.Supports(capability)
+	}
+
+	// The uptime is only available through TR-064, which requires a login.
+	if options.Username != "" && options.Password != "" {
+		if client, _, err = loginReporting(options, progress); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprint(progress, "Retrieving device info… ")
+		var deviceInfo api.DeviceInfo
+		if deviceInfo, err = client.GetDeviceInfo(); err != nil {
+			_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+			return err
+		}
+		_, _ = fmt.Fprintln(progress, "Done.")
+		if info.Serial == "" {
+			info.Serial = deviceInfo.SerialNumber
+		}
+		uptime := deviceInfo.Uptime.Seconds()
+		info.Uptime = &uptime
+	}
+
+	if command.Json {
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(info)
+	}
+
+	_, _ = fmt.Fprintf(output, "Model:             %s\n", info.Model)
+	_, _ = fmt.Fprintf(output, "Hardware revision: %s\n", info.HWRevision)
+	_, _ = fmt.Fprintf(output, "Serial:            %s\n", info.Serial)
+	_, _ = fmt.Fprintf(output, "FRITZ!OS:          %s (%s)\n", info.OSVersion, info.Firmware)
+	if info.Uptime != nil {
+		_, _ = fmt.Fprintf(output, "Uptime:            %s\n", (time.Duration(*info.Uptime) * time.Second).String())
+	}
+	_, _ = fmt.Fprintf(output, "Language:          %s\n", info.Language)
+	_, _ = fmt.Fprintln(output, "Capabilities:")
+	for _, capability := range api.Capabilities {
+		supported := "unsupported"
+		if info.Capabilities[string(capability)] {
+			supported = "supported"
+		}
+		_, _ = fmt.Fprintf(output, "  %-20s %s\n", capability, supported)
+	}
+	return nil
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fritzbox-client/api"
+	"gopkg.in/yaml.v3"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+type portForwardCommand struct {
+	List   *portForwardListCommand   `arg:"subcommand:list"`
+	Add    *portForwardAddCommand    `arg:"subcommand:add"`
+	Remove *portForwardRemoveCommand `arg:"subcommand:remove"`
+	Apply  *portForwardApplyCommand  `arg:"subcommand:apply"`
+}
+
+type portForwardListCommand struct {
+	Format string `arg:"--format" default:"table" placeholder:"<table|json>"`
+}
+
+// portForwardMarker is appended to the description of the rules portforward
+// apply creates, so that --prune leaves rules created elsewhere, like UPnP
+// mappings of devices, alone.
+const portForwardMarker = " [fritzbox-client]"
+
+// portForwardRule is a rule as given on the command line or in the
+// configuration document of portforward apply. For IPv4, To is the address
+// or hostname of the target, for IPv6 and dual stack openings the name or MAC
+// address of the device.
+type portForwardRule struct {
+	Family      string `arg:"--family" default:"ipv4" placeholder:"<ipv4|ipv6|both>" yaml:"family,omitempty"`
+	Protocol    string `arg:"--protocol" default:"tcp" placeholder:"<tcp|udp>" yaml:"protocol"`
+	Port        int    `arg:"--port,required" placeholder:"port" yaml:"port"`
+	EndPort     int    `arg:"--end-port" placeholder:"port" yaml:"end_port,omitempty"`
+	To          string `arg:"--to,required" placeholder:"host" yaml:"to"`
+	ToPort      int    `arg:"--to-port" placeholder:"port" yaml:"to_port,omitempty"`
+	RemoteHost  string `arg:"--remote-host" placeholder:"address" yaml:"remote_host,omitempty"`
+	Description string `arg:"--description" placeholder:"text" yaml:"description,omitempty"`
+	Disabled    bool   `arg:"--disabled" yaml:"disabled,omitempty"`
+}
+
+type portForwardAddCommand struct {
+	portForwardRule
+}
+
+type portForwardRemoveCommand struct {
+	Family     string `arg:"--family" default:"ipv4" placeholder:"<ipv4|ipv6|both>"`
+	Protocol   string `arg:"--protocol" default:"tcp" placeholder:"<tcp|udp>"`
+	Port       int    `arg:"--port,required" placeholder:"port"`
+	Device     string `arg:"--device" placeholder:"device"`
+	RemoteHost string `arg:"--remote-host" placeholder:"address"`
+}
+
+type portForwardApplyCommand struct {
+	File   string `arg:"-f,--file,required" placeholder:"file"`
+	Prune  bool   `arg:"--prune"`
+	DryRun bool   `arg:"--dry-run"`
+}
+
+type portForwardConfig struct {
+	Forwards []portForwardRule `yaml:"forwards"`
+}
+
+// portForwardInfo is the output form of api.PortForward.
+type portForwardInfo struct {
+	Family         string `json:"family"`
+	Protocol       string `json:"protocol"`
+	Port           int    `json:"port"`
+	EndPort        int    `json:"end_port,omitempty"`
+	InternalClient string `json:"internal_client,omitempty"`
+	InternalPort   int    `json:"internal_port,omitempty"`
+	Device         string `json:"device,omitempty"`
+	Description    string `json:"description"`
+	Enabled        bool   `json:"enabled"`
+	RemoteHost     string `json:"remote_host,omitempty"`
+}
+
+func (c *portForwardCommand) task() string {
+	switch {
+	case c.List != nil:
+		return "list"
+	case c.Add != nil:
+		return "add"
+	case c.Remove != nil:
+		return "remove"
+	case c.Apply != nil:
+		return "apply"
+	default:
+		return ""
+	}
+}
+
+func commandPortForward(options args) error {
+	switch options.PortForward.task() {
+	case "list":
+		return portForwardList(options, options.PortForward.List)
+	case "add":
+		return portForwardAdd(options, options.PortForward.Add)
+	case "remove":
+		return portForwardRemove(options, options.PortForward.Remove)
+	case "apply":
+		return portForwardApply(options, options.PortForward.Apply)
+	}
+	return nil
+}
+
+// portForwardFamily returns the family of a rule as shown to the user.
+func portForwardFamily(forward api.PortForward) string {
+	if forward.DualStack {
+		return api.FamilyBoth
+	}
+	return forward.Family
+}
+
+// describePortForward formats a rule for the change log.
+func describePortForward(forward api.PortForward) string {
+	ports := fmt.Sprint(forward.Port)
+	if forward.EndPort > forward.Port {
+		ports = fmt.Sprintf("%d-%d", forward.Port, forward.EndPort)
+	}
+	var target string
+	if forward.Family == api.FamilyIPv6 {
+		target = forward.DeviceName
+		if target == "" {
+			target = forward.Device
+		}
+	} else {
+		target = fmt.Sprintf("%s:%d", forward.InternalClient, forward.InternalPort)
+	}
+	if forward.RemoteHost != "" {
+		ports = fmt.Sprintf("%s from %s", ports, forward.RemoteHost)
+	}
+	description := fmt.Sprintf("%s %s %s -> %s", portForwardFamily(forward), forward.Protocol, ports, target)
+	if forward.Description != "" {
+		description += fmt.Sprintf(" (%s)", forward.Description)
+	}
+	return description
+}
+
+// deviceResolver looks up devices for rules, retrieving the device list only
+// when a rule names a device instead of an IPv4 address.
+type deviceResolver struct {
+	client  *api.FritzboxClient
+	sid     api.SessionID
+	devices []api.LanDevice
+}
+
+func (r *deviceResolver) find(name string) (api.LanDevice, error) {
+	if r.devices == nil {
+		var err error
+		if r.devices, err = r.client.ListLanDevices(r.sid); err != nil {
+			return api.LanDevice{}, err
+		}
+	}
+	mac, macErr := net.ParseMAC(name)
+	var matches []api.LanDevice
+	for _, device := range r.devices {
+		if strings.EqualFold(device.Name, name) || (macErr == nil && strings.EqualFold(device.MAC, mac.String())) {
+			matches = append(matches, device)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return api.LanDevice{}, fmt.Errorf("%w: %s", api.ErrDeviceNotFound, name)
+	case 1:
+		return matches[0], nil
+	}
+	return api.LanDevice{}, fmt.Errorf("device name %s is ambiguous, use its MAC address", name)
+}
+
+// resolve validates the rule and turns it into the form of the box.
+func (r *deviceResolver) resolve(rule portForwardRule) (api.PortForward, error) {
+	forward := api.PortForward{
+		Family:      strings.ToLower(rule.Family),
+		Protocol:    strings.ToUpper(rule.Protocol),
+		Port:        rule.Port,
+		EndPort:     rule.EndPort,
+		Description: rule.Description,
+		Enabled:     !rule.Disabled,
+	}
+	if forward.Family == "" {
+		forward.Family = api.FamilyIPv4
+	}
+	if forward.Family == api.FamilyBoth {
+		forward.Family, forward.DualStack = api.FamilyIPv6, true
+	}
+	if forward.Protocol == "" {
+		forward.Protocol = "TCP"
+	}
+	if forward.Protocol != "TCP" && forward.Protocol != "UDP" {
+		return forward, fmt.Errorf("unknown protocol %q, expected tcp or udp", rule.Protocol)
+	}
+	if forward.Port < 1 || forward.Port > 65535 {
+		return forward, fmt.Errorf("invalid port %d", rule.Port)
+	}
+	if rule.To == "" {
+		return forward, fmt.Errorf("rule for port %d has no target", rule.Port)
+	}
+
+	switch forward.Family {
+	case api.FamilyIPv4:
+		if rule.RemoteHost != "" {
+			if address, err := netip.ParseAddr(rule.RemoteHost); err != nil || !address.Is4() {
+				return forward, fmt.Errorf("invalid remote host %q, expected an IPv4 address", rule.RemoteHost)
+			}
+			forward.RemoteHost = rule.RemoteHost
+		}
+		forward.InternalPort = rule.ToPort
+		if forward.InternalPort == 0 {
+			forward.InternalPort = forward.Port
+		}
+		if address, err := netip.ParseAddr(rule.To); err == nil && address.Is4() {
+			forward.InternalClient = rule.To
+			return forward, nil
+		}
+		device, err := r.find(rule.To)
+		if err != nil {
+			return forward, err
+		}
+		if device.IPv4 == "" {
+			return forward, fmt.Errorf("device %s has no IPv4 address", rule.To)
+		}
+		forward.InternalClient = device.IPv4
+	case api.FamilyIPv6:
+		if forward.EndPort != 0 && forward.EndPort < forward.Port {
+			return forward, fmt.Errorf("invalid port range %d-%d", forward.Port, forward.EndPort)
+		}
+		if forward.EndPort == 0 {
+			forward.EndPort = forward.Port
+		}
+		device, err := r.find(rule.To)
+		if err != nil {
+			return forward, err
+		}
+		forward.Device, forward.DeviceName = device.UID, device.Name
+	default:
+		return forward, fmt.Errorf("unknown address family %q, expected ipv4, ipv6 or both", rule.Family)
+	}
+	return forward, nil
+}
+
+// portForwardChanges describes how wanted differs from current.
+func portForwardChanges(current api.PortForward, wanted api.PortForward) []string {
+	var changes []string
+	if current.InternalClient != wanted.InternalClient {
+		changes = append(changes, fmt.Sprintf("internal_client: %q -> %q", current.InternalClient, wanted.InternalClient))
+	}
+	if current.InternalPort != wanted.InternalPort {
+		changes = append(changes, fmt.Sprintf("internal_port: %d -> %d", current.InternalPort, wanted.InternalPort))
+	}
+	if current.Family == api.FamilyIPv6 && current.EndPort != wanted.EndPort {
+		changes = append(changes, fmt.Sprintf("end_port: %d -> %d", current.EndPort, wanted.EndPort))
+	}
+	if current.DualStack != wanted.DualStack {
+		changes = append(changes, fmt.Sprintf("family: %s -> %s", portForwardFamily(current), portForwardFamily(wanted)))
+	}
+	if current.Description != wanted.Description {
+		changes = append(changes, fmt.Sprintf("description: %q -> %q", current.Description, wanted.Description))
+	}
+	if current.Enabled != wanted.Enabled {
+		changes = append(changes, fmt.Sprintf("enabled: %t -> %t", current.Enabled, wanted.Enabled))
+	}
+	return changes
+}
+
+func portForwardList(options args, command *portForwardListCommand) error {
+	progress, output := outputWriters(command.Format != "table")
+	switch command.Format {
+	case "table", "json":
+	default:
+		err := fmt.Errorf("unknown format %q, expected table or json", command.Format)
+		_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+		return err
+	}
+
+	client, sessionInfo, err := loginReporting(options, progress)
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprint(progress, "Querying port forwardings… ")
+	forwards, warning, err := client.ListPortForwards(sessionInfo.Sid)
+	if warning != nil {
+		_, _ = fmt.Fprintf(progress, "Warning: %s. ", warning.Error())
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+		return err
+	}
+	_, _ = fmt.Fprintf(progress, "Found %d rules.\n", len(forwards))
+
+	if command.Format == "json" {
+		infos := make([]portForwardInfo, 0, len(forwards))
+		for _, forward := range forwards {
+			infos = append(infos, portForwardInfo{
+				Family:         portForwardFamily(forward),
+				Protocol:       forward.Protocol,
+				Port:           forward.Port,
+				EndPort:        forward.EndPort,
+				InternalClient: forward.InternalClient,
+				InternalPort:   forward.InternalPort,
+				Device:         forward.DeviceName,
+				Description:    forward.Description,
+				Enabled:        forward.Enabled,
+				RemoteHost:     forward.RemoteHost,
+			})
+		}
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(infos)
+	}
+
+	writer := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "FAMILY\tPROTOCOL\tPORT\tTARGET\tENABLED\tDESCRIPTION")
+	for _, forward := range forwards {
+		ports := fmt.Sprint(forward.Port)
+		target := fmt.Sprintf("%s:%d", forward.InternalClient, forward.InternalPort)
+		if forward.Family == api.FamilyIPv6 {
+			if forward.EndPort > forward.Port {
+				ports = fmt.Sprintf("%d-%d", forward.Port, forward.EndPort)
+			}
+			target = forward.DeviceName
+		}
+		enabled := "no"
+		if forward.Enabled {
+			enabled = "yes"
+		}
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", portForwardFamily(forward), forward.Protocol, ports, target, enabled, forward.Description)
+	}
+	return writer.Flush()
+}
+
+func portForwardAdd(options args, command *portForwardAddCommand) error {
+	client, sessionInfo, err := login(options)
+	if err != nil {
+		return err
+	}
+
+	resolver := deviceResolver{client: &client, sid: sessionInfo.Sid}
+	var forward api.PortForward
+	if forward, err = resolver.resolve(command.portForwardRule); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Print("Querying port forwardings… ")
+	forwards, warning, err := client.ListPortForwards(sessionInfo.Sid)
+	if warning != nil {
+		fmt.Printf("Warning: %s. ", warning.Error())
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Printf("Found %d rules.\n", len(forwards))
+	for _, current := range forwards {
+		if current.Key() != forward.Key() {
+			continue
+		}
+		fmt.Printf("Updating %s… ", describePortForward(forward))
+		if err = client.UpdatePortForward(sessionInfo.Sid, current, forward); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		fmt.Println("Done.")
+		return nil
+	}
+
+	fmt.Printf("Adding %s… ", describePortForward(forward))
+	if err = client.AddPortForward(sessionInfo.Sid, forward); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Println("Done.")
+	return nil
+}
+
+func portForwardRemove(options args, command *portForwardRemoveCommand) error {
+	family := strings.ToLower(command.Family)
+	if family == api.FamilyBoth {
+		family = api.FamilyIPv6
+	}
+	if family == api.FamilyIPv6 && command.Device == "" {
+		err := errors.New("removing an IPv6 opening requires --device")
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+
+	client, sessionInfo, err := login(options)
+	if err != nil {
+		return err
+	}
+
+	wanted := api.PortForward{Family: family, Protocol: strings.ToUpper(command.Protocol), Port: command.Port}
+	if wanted.Family == api.FamilyIPv4 {
+		wanted.RemoteHost = command.RemoteHost
+	} else if wanted.Family == api.FamilyIPv6 {
+		resolver := deviceResolver{client: &client, sid: sessionInfo.Sid}
+		var device api.LanDevice
+		if device, err = resolver.find(command.Device); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		wanted.Device = device.UID
+	}
+
+	fmt.Print("Querying port forwardings… ")
+	forwards, warning, err := client.ListPortForwards(sessionInfo.Sid)
+	if warning != nil {
+		fmt.Printf("Warning: %s. ", warning.Error())
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Printf("Found %d rules.\n", len(forwards))
+
+	for _, current := range forwards {
+		if current.Key() != wanted.Key() {
+			continue
+		}
+		fmt.Printf("Removing %s… ", describePortForward(current))
+		if err = client.DeletePortForward(sessionInfo.Sid, current); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		fmt.Println("Done.")
+		return nil
+	}
+	err = fmt.Errorf("no %s %s rule for port %d", wanted.Family, wanted.Protocol, wanted.Port)
+	fmt.Printf("Error: %s\n", err.Error())
+	return err
+}
+
+func portForwardApply(options args, command *portForwardApplyCommand) error {
+	fmt.Printf("Loading configuration from %s… ", command.File)
+	var config portForwardConfig
+	data, err := os.ReadFile(command.File)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	if err = yaml.Unmarshal(data, &config); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Printf("Found %d rules.\n", len(config.Forwards))
+
+	client, sessionInfo, err := login(options)
+	if err != nil {
+		return err
+	}
+
+	resolver := deviceResolver{client: &client, sid: sessionInfo.Sid}
+	wanted := make([]api.PortForward, 0, len(config.Forwards))
+	seen := make(map[string]bool)
+	for _, rule := range config.Forwards {
+		var forward api.PortForward
+		if forward, err = resolver.resolve(rule); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		if seen[forward.Key()] {
+			err = fmt.Errorf("duplicate rule %s", describePortForward(forward))
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		seen[forward.Key()] = true
+		forward.Description += portForwardMarker
+		wanted = append(wanted, forward)
+	}
+
+	fmt.Print("Querying port forwardings… ")
+	forwards, warning, err := client.ListPortForwards(sessionInfo.Sid)
+	if warning != nil {
+		fmt.Printf("Warning: %s. ", warning.Error())
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Printf("Found %d rules.\n", len(forwards))
+	current := make(map[string]api.PortForward, len(forwards))
+	for _, forward := range forwards {
+		current[forward.Key()] = forward
+	}
+
+	for _, forward := range wanted {
+		existing, found := current[forward.Key()]
+		if !found {
+			fmt.Printf("+ %s\n", describePortForward(forward))
+			if command.DryRun {
+				continue
+			}
+			fmt.Printf("Adding %s… ", describePortForward(forward))
+			if err = client.AddPortForward(sessionInfo.Sid, forward); err != nil {
+				fmt.Printf("Error: %s\n", err.Error())
+				return err
+			}
+			fmt.Println("Done.")
+			continue
+		}
+		changes := portForwardChanges(existing, forward)
+		if len(changes) == 0 {
+			continue
+		}
+		fmt.Printf("~ %s\n", describePortForward(existing))
+		for _, change := range changes {
+			fmt.Printf("    %s\n", change)
+		}
+		if command.DryRun {
+			continue
+		}
+		fmt.Printf("Updating %s… ", describePortForward(forward))
+		if err = client.UpdatePortForward(sessionInfo.Sid, existing, forward); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		fmt.Println("Done.")
+	}
+
+	if !command.Prune {
+		return nil
+	}
+	if warning != nil {
+		// Without both sources, the IPv4 halves of dual stack openings look
+		// like rules of their own and would be removed.
+		fmt.Println("Not removing any rules, the list of rules on the box is incomplete.")
+		return nil
+	}
+	for _, forward := range forwards {
+		if seen[forward.Key()] {
+			continue
+		}
+		if !strings.HasSuffix(forward.Description, portForwardMarker) {
+			fmt.Printf("Keeping %s, it was not created by portforward apply.\n", describePortForward(forward))
+			continue
+		}
+		fmt.Printf("- %s\n", describePortForward(forward))
+		if command.DryRun {
+			continue
+		}
+		fmt.Printf("Removing %s… ", describePortForward(forward))
+		if err = client.DeletePortForward(sessionInfo.Sid, forward); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		fmt.Println("Done.")
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"fritzbox-client/api"
+	"io"
+	"time"
+)
+
+type rebootCommand struct {
+	NoWait  bool          `arg:"--no-wait"`
+	Online  bool          `arg:"--online"`
+	Timeout time.Duration `arg:"--timeout" default:"10m" placeholder:"duration"`
+	Json    bool          `arg:"--json"`
+}
+
+// readyTimings is the machine-readable form of api.ReadyReport in seconds
+// since the reboot was triggered.
+type readyTimings struct {
+	Restarted bool     `json:"restarted"`
+	Down      float64  `json:"down"`
+	Reachable float64  `json:"reachable"`
+	Online    *float64 `json:"online,omitempty"`
+}
+
+func printReadyReport(output io.Writer, report api.ReadyReport, online bool) {
+	if report.Restarted {
+		_, _ = fmt.Fprintf(output, "Down after:      %s\n", report.Down.Round(time.Second))
+	}
+	_, _ = fmt.Fprintf(output, "Reachable after: %s\n", report.Reachable.Round(time.Second))
+	if online {
+		_, _ = fmt.Fprintf(output, "Online after:    %s\n", report.Online.Round(time.Second))
+	}
+}
+
+func commandReboot(options args) error {
+	command := options.Reboot
+	progress, output := outputWriters(command.Json)
+
+	client, sessionInfo, err := loginReporting(options, progress)
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprint(progress, "Rebooting… ")
+	if err = client.Reboot(sessionInfo.Sid); err != nil {
+		_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+		return err
+	}
+	_, _ = fmt.Fprintln(progress, "Done.")
+	if command.NoWait {
+		return nil
+	}
+
+	if command.Online {
+		_, _ = fmt.Fprint(progress, "Waiting for the box to come back online… ")
+	} else {
+		_, _ = fmt.Fprint(progress, "Waiting for the box to come back… ")
+	}
+	var report api.ReadyReport
+	report, err = client.WaitReady(api.ReadyOptions{DownWithin: 2 * time.Minute, Online: command.Online, Timeout: command.Timeout})
+	if err != nil {
+		_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+		return err
+	}
+	if !report.Restarted {
+		err = fmt.Errorf("the box did not go down within %s", min(2*time.Minute, command.Timeout))
+		_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+		return err
+	}
+	_, _ = fmt.Fprintln(progress, "Done.")
+
+	if command.Json {
+		timings := readyTimings{
+			Restarted: report.Restarted,
+			Down:      report.Down.Seconds(),
+			Reachable: report.Reachable.Seconds(),
+		}
+		if command.Online {
+			online := report.Online.Seconds()
+			timings.Online = &online
+		}
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(timings)
+	}
+	printReadyReport(output, report, command.Online)
+	return nil
+}
@@ -1,66 +1,280 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"fritzbox-client/api"
+	"path"
+	"regexp"
 	"slices"
 	"strings"
+	"time"
 )
 
 type sipCommand struct {
-	Task string   `arg:"positional,required" placeholder:"<connect|disconnect|reconnect>"`
-	Ids  []string `arg:"positional" placeholder:"uid"`
+	Connect    *sipToggleCommand `arg:"subcommand:connect"`
+	Disconnect *sipToggleCommand `arg:"subcommand:disconnect"`
+	Reconnect  *sipToggleCommand `arg:"subcommand:reconnect"`
+	Add        *sipAddCommand    `arg:"subcommand:add"`
+	Remove     *sipRemoveCommand `arg:"subcommand:remove"`
+	Export     *sipExportCommand `arg:"subcommand:export"`
+	Apply      *sipApplyCommand  `arg:"subcommand:apply"`
+	Rotate     *sipRotateCommand `arg:"subcommand:rotate-password"`
 }
 
-func commandSip(options args) error {
-	var err error
+// sipSelector selects phone numbers by uid, number, name, provider or
+// registration state. Patterns are globs, or regular expressions when
+// enclosed in slashes. Without any selector all SIP numbers are selected.
+type sipSelector struct {
+	Ids        []string `arg:"positional" placeholder:"uid"`
+	Number     string   `arg:"--number" placeholder:"pattern"`
+	Name       string   `arg:"--name" placeholder:"pattern"`
+	Provider   string   `arg:"--provider" placeholder:"pattern"`
+	Registered *bool    `arg:"--registered" placeholder:"bool"`
+	Types      []string `arg:"--type,separate" placeholder:"type"`
+	All        bool     `arg:"--all"`
+}
+
+type sipToggleCommand struct {
+	sipSelector
+}
+
+type sipAddCommand struct {
+	Provider     string `arg:"--provider,required" placeholder:"provider"`
+	AreaCode     string `arg:"--area-code" placeholder:"area_code"`
+	LocalNumber  string `arg:"--number,required" placeholder:"number"`
+	Username     string `arg:"--username,required" placeholder:"username"`
+	PasswordFrom string `arg:"--password-from" default:"stdin" placeholder:"<file:PATH|env:NAME|stdin>"`
+}
+
+type sipRemoveCommand struct {
+	sipSelector
+}
+
+type sipRotateCommand struct {
+	Id           string        `arg:"positional,required" placeholder:"uid"`
+	PasswordFrom string        `arg:"--password-from" default:"stdin" placeholder:"<file:PATH|env:NAME|stdin>"`
+	Timeout      time.Duration `arg:"--timeout" default:"60s" placeholder:"duration"`
+}
 
-	var client api.FritzboxClient
-	if client, err = api.NewClient(options.Hostname); err != nil {
+func (c *sipCommand) task() string {
+	switch {
+	case c.Connect != nil:
+		return "connect"
+	case c.Disconnect != nil:
+		return "disconnect"
+	case c.Reconnect != nil:
+		return "reconnect"
+	case c.Add != nil:
+		return "add"
+	case c.Remove != nil:
+		return "remove"
+	case c.Export != nil:
+		return "export"
+	case c.Apply != nil:
+		return "apply"
+	case c.Rotate != nil:
+		return "rotate-password"
+	default:
+		return ""
+	}
+}
+
+func commandSip(options args) error {
+	client, sessionInfo, err := login(options)
+	if err != nil {
 		return err
 	}
 
-	fmt.Printf("Logging in to %s as %s… ", options.Hostname, options.Username)
-	var sessionInfo api.SessionInfo
-	if sessionInfo, err = client.Login(options.Username, options.Password); err != nil {
+	switch options.Sip.task() {
+	case "connect":
+		return sipToggle(&client, sessionInfo.Sid, options.Sip.Connect.sipSelector, false, true)
+	case "disconnect":
+		return sipToggle(&client, sessionInfo.Sid, options.Sip.Disconnect.sipSelector, true, false)
+	case "reconnect":
+		return sipToggle(&client, sessionInfo.Sid, options.Sip.Reconnect.sipSelector, true, true)
+	case "add":
+		return sipAdd(&client, sessionInfo.Sid, options.Sip.Add)
+	case "remove":
+		return sipRemove(&client, sessionInfo.Sid, options.Sip.Remove.sipSelector)
+	case "export":
+		return sipExport(&client, sessionInfo.Sid, options.Sip.Export)
+	case "apply":
+		return sipApply(&client, sessionInfo.Sid, options.Sip.Apply)
+	case "rotate-password":
+		return sipRotatePassword(&client, sessionInfo.Sid, options.Sip.Rotate)
+	}
+	return nil
+}
+
+func compilePattern(pattern string) (func(string) bool, error) {
+	if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		expression, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, err
+		}
+		return expression.MatchString, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return func(value string) bool {
+		matched, _ := path.Match(pattern, value)
+		return matched
+	}, nil
+}
+
+func (s *sipSelector) empty() bool {
+	return len(s.Ids) == 0 && s.Number == "" && s.Name == "" && s.Provider == "" && s.Registered == nil && len(s.Types) == 0 && !s.All
+}
+
+// numberTypes returns the selected number types, SIP numbers unless --type or
+// --all is given. Nil means every type.
+func (s *sipSelector) numberTypes() []string {
+	switch {
+	case s.All:
+		return nil
+	case len(s.Types) > 0:
+		return s.Types
+	default:
+		return []string{"sip"}
+	}
+}
+
+func (s *sipSelector) filter() (func(api.PhoneNumber) bool, error) {
+	var ids []func(string) bool
+	for _, id := range s.Ids {
+		match, err := compilePattern(id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, match)
+	}
+	patterns := make(map[*string]func(string) bool)
+	for _, pattern := range []*string{&s.Number, &s.Name, &s.Provider} {
+		if *pattern == "" {
+			continue
+		}
+		match, err := compilePattern(*pattern)
+		if err != nil {
+			return nil, err
+		}
+		patterns[pattern] = match
+	}
+	types := s.numberTypes()
+
+	return func(phoneNumber api.PhoneNumber) bool {
+		if len(types) > 0 && !slices.Contains(types, phoneNumber.Type) {
+			return false
+		}
+		if len(ids) > 0 && !slices.ContainsFunc(ids, func(match func(string) bool) bool {
+			return match(phoneNumber.Uid)
+		}) {
+			return false
+		}
+		if match, ok := patterns[&s.Number]; ok && !match(phoneNumber.Number) && !match(phoneNumber.MsnNumber) && !match(phoneNumber.AreaCode+phoneNumber.LocalNumber) {
+			return false
+		}
+		if match, ok := patterns[&s.Name]; ok && !match(phoneNumber.Name) {
+			return false
+		}
+		if match, ok := patterns[&s.Provider]; ok && !match(phoneNumber.ProviderName) && !match(phoneNumber.ProviderId) {
+			return false
+		}
+		if s.Registered != nil && phoneNumber.Registered.Bool() != *s.Registered {
+			return false
+		}
+		return true
+	}, nil
+}
+
+func selectPhoneNumbers(client *api.FritzboxClient, sid api.SessionID, selector sipSelector) ([]api.PhoneNumber, error) {
+	filter, err := selector.filter()
+	if err != nil {
 		fmt.Printf("Error: %s\n", err.Error())
-		return err
+		return nil, err
 	}
-	fmt.Println("Done.")
 
 	fmt.Print("Querying list of phone numbers… ")
-	phoneNumbers, err := client.ListPhoneNumbers(sessionInfo.Sid)
+	phoneNumbers, err := client.ListPhoneNumbers(sid)
 	if err != nil {
 		fmt.Printf("Error: %s\n", err.Error())
-		return err
+		return nil, err
 	}
-	fmt.Printf("Found %d numbers.\n", len(phoneNumbers))
+	var selected []api.PhoneNumber
 	for _, phoneNumber := range phoneNumbers {
-		if phoneNumber.Type != "sip" {
+		if filter(phoneNumber) {
+			selected = append(selected, phoneNumber)
+		}
+	}
+	if err = selector.checkIds(phoneNumbers, selected); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return nil, err
+	}
+	fmt.Printf("Found %d numbers, %d selected.\n", len(phoneNumbers), len(selected))
+	return selected, nil
+}
+
+// checkIds reports uids that were given explicitly but selected no number,
+// because no number has the uid or only numbers of a type that was not
+// selected.
+func (s *sipSelector) checkIds(phoneNumbers []api.PhoneNumber, selected []api.PhoneNumber) error {
+	for _, id := range s.Ids {
+		match, err := compilePattern(id)
+		if err != nil {
+			return err
+		}
+		matches := func(phoneNumber api.PhoneNumber) bool { return match(phoneNumber.Uid) }
+		if slices.ContainsFunc(selected, matches) {
 			continue
 		}
-		if len(options.Sip.Ids) > 0 && !slices.Contains(options.Sip.Ids, phoneNumber.Uid) {
+		var types []string
+		for _, phoneNumber := range phoneNumbers {
+			if matches(phoneNumber) && !slices.Contains(types, phoneNumber.Type) {
+				types = append(types, phoneNumber.Type)
+			}
+		}
+		if len(types) == 0 {
+			return fmt.Errorf("phone number %s not found", id)
+		}
+		if selectedTypes := s.numberTypes(); selectedTypes != nil && !slices.ContainsFunc(types, func(numberType string) bool {
+			return slices.Contains(selectedTypes, numberType)
+		}) {
+			return fmt.Errorf("phone number %s is of type %s, select it with --type %s", id, strings.Join(types, ", "), types[0])
+		}
+	}
+	return nil
+}
+
+func sipToggle(client *api.FritzboxClient, sid api.SessionID, selector sipSelector, disconnect bool, connect bool) error {
+	phoneNumbers, err := selectPhoneNumbers(client, sid, selector)
+	if err != nil {
+		return err
+	}
+	for _, phoneNumber := range phoneNumbers {
+		if phoneNumber.Type != "sip" {
+			fmt.Printf("Skipping phone number %s: numbers of type %s cannot be connected or disconnected.\n", phoneNumber.Number, phoneNumber.Type)
 			continue
 		}
 		var data api.PhoneNumber
 		fmt.Printf("Loading configuration for phone number %s… ", phoneNumber.Number)
-		data, err = client.GetPhoneNumber(sessionInfo.Sid, phoneNumber.Uid)
+		data, err = client.GetPhoneNumber(sid, phoneNumber.Uid)
 		if err != nil {
 			fmt.Printf("Error: %s\n", err.Error())
 			return err
 		}
 		fmt.Println("Done.")
-		if strings.EqualFold(options.Sip.Task, "disconnect") || strings.EqualFold(options.Sip.Task, "reconnect") {
+		if disconnect {
 			fmt.Printf("Disabling SIP Number %s… ", phoneNumber.Number)
-			if err = client.DisableSIP(sessionInfo.Sid, phoneNumber.Uid); err != nil {
+			if err = client.DisableSIP(sid, data); err != nil {
 				fmt.Printf("Error: %s\n", err.Error())
 				return err
 			}
 			fmt.Println("Done.")
 		}
-		if strings.EqualFold(options.Sip.Task, "connect") || strings.EqualFold(options.Sip.Task, "reconnect") {
+		if connect {
 			fmt.Printf("Enabling SIP Number %s… ", phoneNumber.Number)
-			if err = client.EnableSIP(sessionInfo.Sid, phoneNumber.Uid, data.ProviderId, data.AreaCode, data.LocalNumber, data.Sip.Username, data.Sip.Password); err != nil {
+			if err = client.EnableSIP(sid, data); err != nil {
 				fmt.Printf("Error: %s\n", err.Error())
 				return err
 			}
@@ -69,3 +283,132 @@ func commandSip(options args) error {
 	}
 	return nil
 }
+
+func findSipProvider(providers []api.SipProvider, query string) (api.SipProvider, error) {
+	for _, provider := range providers {
+		if provider.Id == query || strings.EqualFold(provider.Name, query) {
+			return provider, nil
+		}
+	}
+	names := make([]string, 0, len(providers))
+	for _, provider := range providers {
+		names = append(names, fmt.Sprintf("%s (%s)", provider.Name, provider.Id))
+	}
+	return api.SipProvider{}, fmt.Errorf("unknown provider %q, available providers: %s", query, strings.Join(names, ", "))
+}
+
+func sipAdd(client *api.FritzboxClient, sid api.SessionID, options *sipAddCommand) error {
+	fmt.Printf("Reading password from %s… ", options.PasswordFrom)
+	password, err := readSecret(options.PasswordFrom)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	if password == "" {
+		err = errors.New("password is empty")
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Println("Done.")
+
+	fmt.Print("Querying list of SIP providers… ")
+	providers, err := client.ListSIPProviders(sid)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	var provider api.SipProvider
+	if provider, err = findSipProvider(providers, options.Provider); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Printf("Using %s.\n", provider.Name)
+
+	fmt.Printf("Adding SIP Number %s%s… ", options.AreaCode, options.LocalNumber)
+	if err = client.AddSIPNumber(sid, provider.Id, options.AreaCode, options.LocalNumber, options.Username, password); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Println("Done.")
+	return nil
+}
+
+func sipRemove(client *api.FritzboxClient, sid api.SessionID, selector sipSelector) error {
+	if selector.empty() {
+		err := errors.New("refusing to remove numbers without a selector, use --all to remove every number")
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	phoneNumbers, err := selectPhoneNumbers(client, sid, selector)
+	if err != nil {
+		return err
+	}
+	for _, phoneNumber := range phoneNumbers {
+		fmt.Printf("Removing phone number %s… ", phoneNumber.Number)
+		if err = client.DeletePhoneNumber(sid, phoneNumber); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		fmt.Println("Done.")
+	}
+	return nil
+}
+
+func sipRotatePassword(client *api.FritzboxClient, sid api.SessionID, options *sipRotateCommand) error {
+	fmt.Printf("Reading new password from %s… ", options.PasswordFrom)
+	password, err := readSecret(options.PasswordFrom)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	if password == "" {
+		err = errors.New("new password is empty")
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Println("Done.")
+
+	fmt.Printf("Loading configuration for phone number %s… ", options.Id)
+	data, err := client.GetPhoneNumber(sid, options.Id)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Println("Done.")
+
+	oldPassword := data.Sip.Password
+	wasRegistered := data.Registered.Bool() || data.Sip.Registered.Bool()
+	data.Sip.Password = password
+	fmt.Printf("Updating password of SIP Number %s… ", data.Number)
+	if err = client.SaveSIPNumber(sid, data); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Println("Done.")
+
+	if !data.Active.Bool() {
+		fmt.Printf("SIP Number %s is not active, skipping registration check.\n", data.Number)
+		return nil
+	}
+
+	fmt.Printf("Waiting for SIP Number %s to register… ", data.Number)
+	if err = client.WaitSIPRegistered(sid, data.Uid, wasRegistered, options.Timeout); err == nil {
+		fmt.Println("Done.")
+		return nil
+	}
+	fmt.Printf("Error: %s\n", err.Error())
+	// The provider may already have switched to the new password, so the
+	// previous one is only restored for a number known to be unregistered.
+	if !errors.Is(err, api.ErrNotRegistered) {
+		return err
+	}
+
+	fmt.Printf("Restoring previous password of SIP Number %s… ", data.Number)
+	data.Sip.Password = oldPassword
+	if rollbackErr := client.SaveSIPNumber(sid, data); rollbackErr != nil {
+		fmt.Printf("Error: %s\n", rollbackErr.Error())
+		return errors.Join(err, rollbackErr)
+	}
+	fmt.Println("Done.")
+	return fmt.Errorf("registration with new password failed, previous password restored: %w", err)
+}
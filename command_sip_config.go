@@ -0,0 +1,341 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"fritzbox-client/api"
+	"golang.org/x/crypto/scrypt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"slices"
+	"strings"
+)
+
+const redactedPassword = "(redacted)"
+const encryptedPasswordPrefix = "encrypted:"
+
+type sipExportCommand struct {
+	Output    string `arg:"-o,--output,required" placeholder:"file"`
+	Passwords string `arg:"--passwords" default:"plain" placeholder:"<plain|redact|encrypt>"`
+	Secret    string `arg:"--secret,env:FRITZBOX_SECRET" placeholder:"secret"`
+}
+
+type sipApplyCommand struct {
+	File   string `arg:"-f,--file,required" placeholder:"file"`
+	Secret string `arg:"--secret,env:FRITZBOX_SECRET" placeholder:"secret"`
+	Prune  bool   `arg:"--prune"`
+	DryRun bool   `arg:"--dry-run"`
+}
+
+type telephonyConfig struct {
+	Numbers []api.PhoneNumber `yaml:"numbers"`
+}
+
+func deriveSecretKey(secret string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(secret), salt, 32768, 8, 1, 32)
+}
+
+func encryptPassword(secret string, password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := deriveSecretKey(secret, salt)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	data := append(salt, nonce...)
+	data = gcm.Seal(data, nonce, []byte(password), nil)
+	return encryptedPasswordPrefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+func decryptPassword(secret string, value string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPasswordPrefix))
+	if err != nil {
+		return "", err
+	}
+	if len(data) < 16 {
+		return "", errors.New("encrypted password is too short")
+	}
+	key, err := deriveSecretKey(secret, data[:16])
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	data = data[16:]
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("encrypted password is too short")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("unable to decrypt password, wrong secret?")
+	}
+	return string(plaintext), nil
+}
+
+func sipExport(client *api.FritzboxClient, sid api.SessionID, options *sipExportCommand) error {
+	if options.Passwords == "encrypt" && options.Secret == "" {
+		err := errors.New("encrypting passwords requires --secret")
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+
+	fmt.Print("Querying list of phone numbers… ")
+	phoneNumbers, err := client.ListPhoneNumbers(sid)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Printf("Found %d numbers.\n", len(phoneNumbers))
+
+	var config telephonyConfig
+	for _, phoneNumber := range phoneNumbers {
+		if phoneNumber.Type == "sip" {
+			fmt.Printf("Loading configuration for phone number %s… ", phoneNumber.Number)
+			if phoneNumber, err = client.GetPhoneNumber(sid, phoneNumber.Uid); err != nil {
+				fmt.Printf("Error: %s\n", err.Error())
+				return err
+			}
+			fmt.Println("Done.")
+		}
+		switch options.Passwords {
+		case "plain":
+		case "redact":
+			if phoneNumber.Sip.Password != "" {
+				phoneNumber.Sip.Password = redactedPassword
+			}
+		case "encrypt":
+			if phoneNumber.Sip.Password != "" {
+				if phoneNumber.Sip.Password, err = encryptPassword(options.Secret, phoneNumber.Sip.Password); err != nil {
+					fmt.Printf("Error: %s\n", err.Error())
+					return err
+				}
+			}
+		default:
+			err = fmt.Errorf("unknown password mode %q", options.Passwords)
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		config.Numbers = append(config.Numbers, phoneNumber)
+	}
+
+	fmt.Printf("Writing configuration to %s… ", options.Output)
+	var data []byte
+	if data, err = yaml.Marshal(config); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	if err = os.WriteFile(options.Output, data, 0600); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Println("Done.")
+	return nil
+}
+
+// findConfiguredNumber returns the number on the box that wanted describes.
+// Numbers are matched by the number itself; uids differ between boxes and
+// only decide between several numbers that match.
+func findConfiguredNumber(phoneNumbers []api.PhoneNumber, wanted api.PhoneNumber) (api.PhoneNumber, bool) {
+	var found []api.PhoneNumber
+	for _, phoneNumber := range phoneNumbers {
+		if wanted.LocalNumber != "" && phoneNumber.AreaCode == wanted.AreaCode && phoneNumber.LocalNumber == wanted.LocalNumber {
+			found = append(found, phoneNumber)
+		} else if wanted.LocalNumber == "" && wanted.Number != "" && phoneNumber.Number == wanted.Number {
+			found = append(found, phoneNumber)
+		}
+	}
+	if len(found) == 0 {
+		return api.PhoneNumber{}, false
+	}
+	for _, phoneNumber := range found {
+		if wanted.Uid != "" && phoneNumber.Uid == wanted.Uid {
+			return phoneNumber, true
+		}
+	}
+	return found[0], true
+}
+
+// mergeSipNumber copies every setting sip export writes and the box accepts
+// from wanted into current and describes every change it made. Settings are
+// named by their sip_edit form field, and a redacted password keeps the
+// current one.
+func mergeSipNumber(current *api.PhoneNumber, wanted api.PhoneNumber) []string {
+	password := wanted.Sip.Password
+	if password == redactedPassword {
+		password = current.Sip.Password
+	}
+	currentSettings, wantedSettings := api.SIPSettings(*current), api.SIPSettings(wanted)
+	var names []string
+	for name := range currentSettings {
+		names = append(names, name)
+	}
+	for name := range wantedSettings {
+		if !currentSettings.Has(name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	var changes []string
+	for _, name := range names {
+		if name == "password" {
+			continue
+		}
+		if currentValue, wantedValue := currentSettings.Get(name), wantedSettings.Get(name); currentValue != wantedValue {
+			changes = append(changes, fmt.Sprintf("%s: %q -> %q", name, currentValue, wantedValue))
+		}
+	}
+	if current.Sip.Password != password {
+		changes = append(changes, "password: changed")
+	}
+
+	current.Active = wanted.Active
+	current.ProviderId = wanted.ProviderId
+	current.AreaCode = wanted.AreaCode
+	current.LocalNumber = wanted.LocalNumber
+	current.Sip = wanted.Sip
+	current.Sip.Password = password
+	current.TelConfig = wanted.TelConfig
+	return changes
+}
+
+func sipApply(client *api.FritzboxClient, sid api.SessionID, options *sipApplyCommand) error {
+	fmt.Printf("Loading configuration from %s… ", options.File)
+	var config telephonyConfig
+	data, err := os.ReadFile(options.File)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	if err = yaml.Unmarshal(data, &config); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	for i := range config.Numbers {
+		password := &config.Numbers[i].Sip.Password
+		if !strings.HasPrefix(*password, encryptedPasswordPrefix) {
+			continue
+		}
+		if options.Secret == "" {
+			err = errors.New("configuration contains encrypted passwords, but no --secret was given")
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		if *password, err = decryptPassword(options.Secret, *password); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+	}
+	fmt.Printf("Found %d numbers.\n", len(config.Numbers))
+
+	fmt.Print("Querying list of phone numbers… ")
+	phoneNumbers, err := client.ListPhoneNumbers(sid)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Printf("Found %d numbers.\n", len(phoneNumbers))
+
+	var sipNumbers []api.PhoneNumber
+	for _, phoneNumber := range phoneNumbers {
+		if phoneNumber.Type != "sip" {
+			continue
+		}
+		fmt.Printf("Loading configuration for phone number %s… ", phoneNumber.Number)
+		if phoneNumber, err = client.GetPhoneNumber(sid, phoneNumber.Uid); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		fmt.Println("Done.")
+		sipNumbers = append(sipNumbers, phoneNumber)
+	}
+
+	configured := make(map[string]bool)
+	for _, wanted := range config.Numbers {
+		if wanted.Type != "" && wanted.Type != "sip" {
+			continue
+		}
+		current, found := findConfiguredNumber(sipNumbers, wanted)
+		if !found {
+			fmt.Printf("+ %s%s\n", wanted.AreaCode, wanted.LocalNumber)
+			if wanted.Sip.Password == redactedPassword {
+				err = fmt.Errorf("cannot create %s%s with a redacted password", wanted.AreaCode, wanted.LocalNumber)
+				fmt.Printf("Error: %s\n", err.Error())
+				return err
+			}
+			if options.DryRun {
+				continue
+			}
+			wanted.Uid = ""
+			fmt.Printf("Adding SIP Number %s%s… ", wanted.AreaCode, wanted.LocalNumber)
+			if err = client.SaveSIPNumber(sid, wanted); err != nil {
+				fmt.Printf("Error: %s\n", err.Error())
+				return err
+			}
+			fmt.Println("Done.")
+			continue
+		}
+		configured[current.Uid] = true
+		changes := mergeSipNumber(&current, wanted)
+		if len(changes) == 0 {
+			continue
+		}
+		fmt.Printf("~ %s (%s)\n", current.Number, current.Uid)
+		for _, change := range changes {
+			fmt.Printf("    %s\n", change)
+		}
+		if options.DryRun {
+			continue
+		}
+		fmt.Printf("Updating SIP Number %s… ", current.Number)
+		if err = client.SaveSIPNumber(sid, current); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		fmt.Println("Done.")
+	}
+
+	if !options.Prune {
+		return nil
+	}
+	for _, current := range sipNumbers {
+		if configured[current.Uid] {
+			continue
+		}
+		fmt.Printf("- %s (%s)\n", current.Number, current.Uid)
+		if options.DryRun {
+			continue
+		}
+		fmt.Printf("Removing SIP Number %s… ", current.Number)
+		if err = client.DeleteSIPNumber(sid, current.Uid); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return err
+		}
+		fmt.Println("Done.")
+	}
+	return nil
+}
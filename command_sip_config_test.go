@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fritzbox-client/api"
+	"testing"
+)
+
+func TestFindConfiguredNumber(t *testing.T) {
+	box := []api.PhoneNumber{
+		{Uid: "1", AreaCode: "030", LocalNumber: "111"},
+		{Uid: "2", AreaCode: "030", LocalNumber: "222"},
+		{Uid: "3", AreaCode: "030", LocalNumber: "222"},
+		{Uid: "4", Number: "0401234"},
+	}
+	tests := []struct {
+		name   string
+		wanted api.PhoneNumber
+		uid    string
+	}{
+		{"number", api.PhoneNumber{AreaCode: "030", LocalNumber: "111"}, "1"},
+		{"number with uid of another number", api.PhoneNumber{Uid: "2", AreaCode: "030", LocalNumber: "111"}, "1"},
+		{"uid decides between duplicates", api.PhoneNumber{Uid: "3", AreaCode: "030", LocalNumber: "222"}, "3"},
+		{"unknown uid among duplicates", api.PhoneNumber{Uid: "9", AreaCode: "030", LocalNumber: "222"}, "2"},
+		{"full number", api.PhoneNumber{Number: "0401234"}, "4"},
+		{"unknown number with known uid", api.PhoneNumber{Uid: "1", AreaCode: "030", LocalNumber: "333"}, ""},
+	}
+	for _, test := range tests {
+		got, found := findConfiguredNumber(box, test.wanted)
+		if test.uid == "" {
+			if found {
+				t.Errorf("%s: expected no match, got %q", test.name, got.Uid)
+			}
+			continue
+		}
+		if !found || got.Uid != test.uid {
+			t.Errorf("%s: got %q (found: %v), want %q", test.name, got.Uid, found, test.uid)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"fritzbox-client/api"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+type wolCommand struct {
+	Targets []string      `arg:"positional,required" placeholder:"mac|hostname"`
+	Wait    bool          `arg:"--wait"`
+	Timeout time.Duration `arg:"--timeout" default:"5m" placeholder:"duration"`
+}
+
+// wolTarget is a device to wake, named as given on the command line.
+type wolTarget struct {
+	name string
+	mac  string
+}
+
+// resolveWolTargets maps hostnames to MAC addresses through the host list,
+// which is only retrieved if a target is not a MAC address.
+func resolveWolTargets(client *api.FritzboxClient, sid api.SessionID, names []string) ([]wolTarget, error) {
+	var hosts []api.LanDevice
+	targets := make([]wolTarget, 0, len(names))
+	for _, name := range names {
+		if mac, err := net.ParseMAC(name); err == nil {
+			targets = append(targets, wolTarget{name: name, mac: strings.ToUpper(mac.String())})
+			continue
+		}
+		if hosts == nil {
+			var warning, err error
+			hosts, warning, err = client.ListHosts(sid)
+			if warning != nil {
+				fmt.Printf("Warning: %s. ", warning.Error())
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		var macs []string
+		for _, host := range hosts {
+			if strings.EqualFold(host.Name, name) && host.MAC != "" {
+				macs = append(macs, host.MAC)
+			}
+		}
+		switch len(macs) {
+		case 0:
+			return nil, fmt.Errorf("%w: %s", api.ErrDeviceNotFound, name)
+		case 1:
+			targets = append(targets, wolTarget{name: name, mac: macs[0]})
+		default:
+			return nil, fmt.Errorf("hostname %s is ambiguous, use one of the MAC addresses %s", name, strings.Join(macs, ", "))
+		}
+	}
+	return targets, nil
+}
+
+func commandWol(options args) error {
+	command := options.Wol
+	client, sessionInfo, err := login(options)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print("Resolving devices… ")
+	var targets []wolTarget
+	if targets, err = resolveWolTargets(&client, sessionInfo.Sid, command.Targets); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return err
+	}
+	fmt.Println("Done.")
+
+	var failed []wolTarget
+	wasActive := make(map[string]bool, len(targets))
+	if command.Wait {
+		for _, target := range targets {
+			fmt.Printf("Checking state of %s… ", target.name)
+			active, err := client.HostActive(target.mac)
+			if err != nil {
+				fmt.Printf("Error: %s\n", err.Error())
+				failed = append(failed, target)
+				continue
+			}
+			wasActive[target.mac] = active
+			if active {
+				fmt.Println("Active.")
+			} else {
+				fmt.Println("Inactive.")
+			}
+		}
+	}
+	for _, target := range targets {
+		if slices.Contains(failed, target) {
+			continue
+		}
+		fmt.Printf("Waking %s (%s)… ", target.name, target.mac)
+		if err = client.WakeOnLAN(target.mac); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			failed = append(failed, target)
+			continue
+		}
+		fmt.Println("Done.")
+	}
+
+	if command.Wait {
+		for _, target := range targets {
+			if slices.Contains(failed, target) {
+				continue
+			}
+			fmt.Printf("Waiting for %s to become active… ", target.name)
+			if err = client.WaitHostActive(target.mac, wasActive[target.mac], command.Timeout); err != nil {
+				fmt.Printf("Error: %s\n", err.Error())
+				failed = append(failed, target)
+				continue
+			}
+			fmt.Println("Done.")
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New("not all devices could be woken")
+	}
+	return nil
+}
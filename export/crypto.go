@@ -0,0 +1,149 @@
+package export
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrNoPassword    = errors.New("configuration export has no password")
+	ErrWrongPassword = errors.New("wrong password for configuration export")
+	ErrInvalidSecret = errors.New("invalid encrypted value")
+)
+
+// secretPrefix marks encrypted values in exports and configuration files.
+const secretPrefix = "$$$$"
+
+// secretEncoding is the base32 variant used for encrypted values, with digits
+// 1 to 6 instead of 2 to 7.
+var secretEncoding = base32.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZ123456").WithPadding(base32.NoPadding)
+
+var secretPattern = regexp.MustCompile(`\$\$\$\$[A-Z1-6]+`)
+
+// Decrypter decrypts the secrets of an export.
+type Decrypter struct {
+	key []byte
+}
+
+// passwordKey derives the AES-256 key from a password: its MD5 hash, padded
+// with zeros.
+func passwordKey(password string) []byte {
+	sum := md5.Sum([]byte(password))
+	return append(sum[:], make([]byte, 16)...)
+}
+
+// decrypt decrypts an encrypted value. The ciphertext is prefixed with the
+// IV, the plaintext consists of the first four bytes of the MD5 hash of the
+// rest, the length of the value as big endian and the value itself.
+func decrypt(key []byte, value string) ([]byte, error) {
+	data, err := secretEncoding.DecodeString(strings.TrimPrefix(value, secretPrefix))
+	if err != nil || len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		return nil, ErrInvalidSecret
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, data[:aes.BlockSize]).CryptBlocks(plaintext, data[aes.BlockSize:])
+
+	length := int(binary.BigEndian.Uint32(plaintext[4:8]))
+	if length > len(plaintext)-8 {
+		return nil, ErrWrongPassword
+	}
+	sum := md5.Sum(plaintext[4 : 8+length])
+	if !bytes.Equal(sum[:4], plaintext[:4]) {
+		return nil, ErrWrongPassword
+	}
+	return plaintext[8 : 8+length], nil
+}
+
+// NewDecrypter checks the password against the Password variable of the
+// export, which holds the key the secrets are encrypted with, encrypted with
+// the export password.
+func (e *Export) NewDecrypter(password string) (*Decrypter, error) {
+	encryptedKey := e.Variable("Password")
+	if !strings.HasPrefix(encryptedKey, secretPrefix) {
+		return nil, ErrNoPassword
+	}
+	key, err := decrypt(passwordKey(password), encryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	switch len(key) {
+	case 16:
+		key = append(key, make([]byte, 16)...)
+	case 32:
+	default:
+		return nil, fmt.Errorf("%w: unexpected key length %d", ErrInvalidSecret, len(key))
+	}
+	return &Decrypter{key: key}, nil
+}
+
+// Decrypt decrypts a single $$$$ value. String values are returned without
+// their terminating NUL byte.
+func (d *Decrypter) Decrypt(value string) (string, error) {
+	plaintext, err := decrypt(d.key, value)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSuffix(plaintext, []byte{0})), nil
+}
+
+// DecryptText replaces all encrypted values in a configuration file by their
+// plaintext, quoted and escaped the way strings appear in it.
+func (d *Decrypter) DecryptText(text []byte) ([]byte, error) {
+	var err error
+	result := secretPattern.ReplaceAllFunc(text, func(value []byte) []byte {
+		plaintext, decryptErr := d.Decrypt(string(value))
+		if decryptErr != nil {
+			err = decryptErr
+			return value
+		}
+		return []byte(strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(plaintext))
+	})
+	return result, err
+}
+
+// Decrypt returns a copy of the export with the secrets in all text sections
+// decrypted.
+func (e *Export) Decrypt(password string) (*Export, error) {
+	decrypter, err := e.NewDecrypter(password)
+	if err != nil {
+		return nil, err
+	}
+	decrypted := *e
+	decrypted.Sections = make([]Section, len(e.Sections))
+	for i, section := range e.Sections {
+		if section.Text() {
+			if section.Content, err = decrypter.DecryptText(section.Content); err != nil {
+				return nil, fmt.Errorf("%s: %w", section.Name, err)
+			}
+		}
+		decrypted.Sections[i] = section
+	}
+	return &decrypted, nil
+}
+
+// MaskSecrets returns a copy of the export with all encrypted values in text
+// sections replaced by a placeholder. As every export encrypts secrets with
+// a fresh IV, this keeps them from showing up as changes in a diff.
+func (e *Export) MaskSecrets() *Export {
+	masked := *e
+	masked.Sections = make([]Section, len(e.Sections))
+	for i, section := range e.Sections {
+		if section.Text() {
+			section.Content = secretPattern.ReplaceAllLiteral(section.Content, []byte(secretPrefix+"(encrypted)"))
+		}
+		masked.Sections[i] = section
+	}
+	return &masked
+}
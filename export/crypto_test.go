@@ -0,0 +1,94 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecrypt(t *testing.T) {
+	export := parseExport(t, "fritzbox.export")
+	decrypted, err := export.Decrypt("export-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	section, _ := decrypted.Section("ar7.cfg")
+	for _, line := range []string{
+		`username = "admin";`,
+		`wlan_key = "correct horse \"battery\"";`,
+		`path = "C:\\Users\\admin";`,
+	} {
+		if !bytes.Contains(section.Content, []byte(line)) {
+			t.Errorf("decrypted ar7.cfg does not contain %s:\n%s", line, section.Content)
+		}
+	}
+	if bytes.Contains(section.Content, []byte(secretPrefix)) {
+		t.Errorf("decrypted ar7.cfg still contains secrets:\n%s", section.Content)
+	}
+
+	original, _ := export.Section("ar7.cfg")
+	if !bytes.Contains(original.Content, []byte(`username = "$$$$`)) {
+		t.Error("Decrypt changed the original export")
+	}
+	binary, _ := export.Section("phonebook.bin")
+	decryptedBinary, _ := decrypted.Section("phonebook.bin")
+	if !bytes.Equal(binary.Content, decryptedBinary.Content) {
+		t.Error("Decrypt changed a binary section")
+	}
+}
+
+func TestNewDecrypterErrors(t *testing.T) {
+	export := parseExport(t, "fritzbox.export")
+	if _, err := export.NewDecrypter("wrong-secret"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("wrong password: expected %v, got %v", ErrWrongPassword, err)
+	}
+
+	withoutPassword := *export
+	withoutPassword.Variables = nil
+	for _, variable := range export.Variables {
+		if variable.Name != "Password" {
+			withoutPassword.Variables = append(withoutPassword.Variables, variable)
+		}
+	}
+	if _, err := withoutPassword.NewDecrypter("export-secret"); !errors.Is(err, ErrNoPassword) {
+		t.Errorf("no password: expected %v, got %v", ErrNoPassword, err)
+	}
+}
+
+func TestDecryptValue(t *testing.T) {
+	decrypter, err := parseExport(t, "fritzbox.export").NewDecrypter("export-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		value string
+		want  string
+		err   error
+	}{
+		{"$$$$N2GZ1KONLYZTOUPPI3EWUVGJJCSDXUL4DUGQQ4WMJIXG11RHTB1A", "admin", nil},
+		{"$$$$AAAA", "", ErrInvalidSecret},
+		{"$$$$0000", "", ErrInvalidSecret},
+		// The password variable is encrypted with another key.
+		{parseExport(t, "fritzbox.export").Variable("Password"), "", ErrWrongPassword},
+	}
+	for _, test := range tests {
+		value, err := decrypter.Decrypt(test.value)
+		if !errors.Is(err, test.err) || value != test.want {
+			t.Errorf("%s: got %q, %v, want %q, %v", test.value, value, err, test.want, test.err)
+		}
+	}
+}
+
+func TestMaskSecrets(t *testing.T) {
+	export := parseExport(t, "fritzbox.export")
+	section, _ := export.MaskSecrets().Section("ar7.cfg")
+	for _, line := range []string{`username = "$$$$(encrypted)";`, `wlan_key = "$$$$(encrypted)";`} {
+		if !bytes.Contains(section.Content, []byte(line)) {
+			t.Errorf("masked ar7.cfg does not contain %s:\n%s", line, section.Content)
+		}
+	}
+	if secretPattern.Match(section.Content) {
+		t.Errorf("masked ar7.cfg still contains secrets:\n%s", section.Content)
+	}
+}
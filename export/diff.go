@@ -0,0 +1,224 @@
+package export
+
+import (
+	"bytes"
+	"strings"
+)
+
+// ChangeKind is the kind of a change between two exports.
+type ChangeKind string
+
+const (
+	Added    ChangeKind = "added"
+	Removed  ChangeKind = "removed"
+	Modified ChangeKind = "modified"
+)
+
+// LineOp is a line of a diff: ' ' for context, '-' for removed and '+' for
+// added lines.
+type LineOp struct {
+	Op   byte
+	Line string
+}
+
+// Change is a difference between two exports. Name is the name of a header
+// variable or, for sections, the configuration file.
+type Change struct {
+	Kind     ChangeKind
+	Section  bool
+	Name     string
+	Old, New string
+	// Lines is the line diff of modified text sections, reduced to the changed
+	// lines and their context.
+	Lines []LineOp
+	// Large is set for modified text sections with too many changed lines to
+	// list them.
+	Large bool
+}
+
+// diffContext is the number of unchanged lines shown around changed ones.
+const diffContext = 3
+
+// maxDiffEdits limits the number of changed lines diffLines looks for, as
+// the memory it needs grows with their square.
+const maxDiffEdits = 2000
+
+// Diff compares two exports: header variables other than the encrypted
+// password, and the configuration files they contain. Encrypted values
+// differ in every export, mask or decrypt them first to compare them.
+func Diff(a *Export, b *Export) []Change {
+	var changes []Change
+
+	for _, variable := range a.Variables {
+		if variable.Name == "Password" {
+			continue
+		}
+		if value, ok := b.LookupVariable(variable.Name); !ok {
+			changes = append(changes, Change{Kind: Removed, Name: variable.Name, Old: variable.Value})
+		} else if value != variable.Value {
+			changes = append(changes, Change{Kind: Modified, Name: variable.Name, Old: variable.Value, New: value})
+		}
+	}
+	for _, variable := range b.Variables {
+		if _, ok := a.LookupVariable(variable.Name); !ok && variable.Name != "Password" {
+			changes = append(changes, Change{Kind: Added, Name: variable.Name, New: variable.Value})
+		}
+	}
+
+	for _, section := range a.Sections {
+		other, ok := b.Section(section.Name)
+		switch {
+		case !ok:
+			changes = append(changes, Change{Kind: Removed, Section: true, Name: section.Name})
+		case bytes.Equal(section.Content, other.Content):
+		case section.Text() && other.Text():
+			lines, ok := diffLines(splitLines(section.Content), splitLines(other.Content))
+			changes = append(changes, Change{
+				Kind:    Modified,
+				Section: true,
+				Name:    section.Name,
+				Lines:   lines,
+				Large:   !ok,
+			})
+		default:
+			changes = append(changes, Change{Kind: Modified, Section: true, Name: section.Name})
+		}
+	}
+	for _, section := range b.Sections {
+		if _, ok := a.Section(section.Name); !ok {
+			changes = append(changes, Change{Kind: Added, Section: true, Name: section.Name})
+		}
+	}
+	return changes
+}
+
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+// diffLines computes the shortest edit script between the lines with the
+// algorithm of Myers and returns the changed lines with their context. It
+// gives up if more than maxDiffEdits lines changed.
+func diffLines(a []string, b []string) ([]LineOp, bool) {
+	// Common prefix and suffix are split off, as the trace grows with the
+	// number of lines.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	var ops []LineOp
+	for _, line := range a[:prefix] {
+		ops = append(ops, LineOp{Op: ' ', Line: line})
+	}
+	edits, ok := myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix], maxDiffEdits)
+	if !ok {
+		return nil, false
+	}
+	ops = append(ops, edits...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, LineOp{Op: ' ', Line: line})
+	}
+	return withContext(ops, diffContext), true
+}
+
+// myers returns the shortest edit script or false if it needs more than
+// maxEdits insertions and deletions. Step d of the trace only keeps the
+// diagonals -d-1 to d+1 it can reach, so the trace needs O(maxEdits²) memory
+// regardless of the number of lines.
+func myers(a []string, b []string, maxEdits int) ([]LineOp, bool) {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		if d > maxEdits {
+			return nil, false
+		}
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		done := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				done = true
+				break
+			}
+		}
+		if done {
+			break
+		}
+	}
+
+	// Walk the trace backwards to collect the operations.
+	var ops []LineOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		// Diagonal k is stored at k+d+1.
+		v := trace[d]
+		k := x - y
+		var previousK int
+		if k == -d || (k != d && v[k+d] < v[k+d+2]) {
+			previousK = k + 1
+		} else {
+			previousK = k - 1
+		}
+		previousX := v[previousK+d+1]
+		previousY := previousX - previousK
+		for x > previousX && y > previousY {
+			x, y = x-1, y-1
+			ops = append(ops, LineOp{Op: ' ', Line: a[x]})
+		}
+		if d > 0 {
+			if x == previousX {
+				y--
+				ops = append(ops, LineOp{Op: '+', Line: b[y]})
+			} else {
+				x--
+				ops = append(ops, LineOp{Op: '-', Line: a[x]})
+			}
+		}
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops, true
+}
+
+// withContext drops unchanged lines further than context lines away from a
+// change, marking the gaps with a context line "…".
+func withContext(ops []LineOp, context int) []LineOp {
+	keep := make([]bool, len(ops))
+	for i, op := range ops {
+		if op.Op == ' ' {
+			continue
+		}
+		for j := max(0, i-context); j <= min(len(ops)-1, i+context); j++ {
+			keep[j] = true
+		}
+	}
+	var result []LineOp
+	for i, op := range ops {
+		if keep[i] {
+			result = append(result, op)
+		} else if len(result) > 0 && result[len(result)-1].Line != "…" && i > 0 && keep[i-1] {
+			result = append(result, LineOp{Op: ' ', Line: "…"})
+		}
+	}
+	return result
+}
@@ -0,0 +1,135 @@
+package export
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := parseExport(t, "fritzbox.export")
+	b := parseExport(t, "fritzbox-updated.export")
+
+	if changes := Diff(a, a); len(changes) != 0 {
+		t.Errorf("an export differs from itself: %+v", changes)
+	}
+
+	want := []Change{
+		{Kind: Modified, Name: "FirmwareVersion", Old: "154.07.57", New: "154.07.59"},
+		{Kind: Removed, Name: "OEM", Old: "avm"},
+		{Kind: Added, Name: "Country", New: "049"},
+		{Kind: Modified, Section: true, Name: "ar7.cfg", Lines: []LineOp{
+			{' ', `        path = "C:\\Users\\admin";`},
+			{' ', `        username = "$$$$(encrypted)";`},
+			{' ', `        wlan_key = "$$$$(encrypted)";`},
+			{'-', `        dns = "192.168.178.1";`},
+			{'+', `        dns = "9.9.9.9";`},
+			{' ', `        ntp = "pool.ntp.org";`},
+			{' ', `        led = yes;`},
+			{' ', `        upnp = no;`},
+			{' ', "…"},
+		}},
+		{Kind: Removed, Section: true, Name: "voip.cfg"},
+		{Kind: Modified, Section: true, Name: "phonebook.bin"},
+		{Kind: Added, Section: true, Name: "user.cfg"},
+	}
+	if changes := Diff(a.MaskSecrets(), b.MaskSecrets()); !reflect.DeepEqual(changes, want) {
+		t.Errorf("got\n%+v\nwant\n%+v", changes, want)
+	}
+}
+
+func TestDiffDecrypted(t *testing.T) {
+	a, err := parseExport(t, "fritzbox.export").Decrypt("export-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := parseExport(t, "fritzbox-updated.export").Decrypt("export-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, change := range Diff(a, b) {
+		if change.Name != "ar7.cfg" {
+			continue
+		}
+		var lines []string
+		for _, op := range change.Lines {
+			if op.Op != ' ' {
+				lines = append(lines, string(op.Op)+strings.TrimSpace(op.Line))
+			}
+		}
+		want := []string{
+			`-wlan_key = "correct horse \"battery\"";`,
+			`-dns = "192.168.178.1";`,
+			`+wlan_key = "new wlan key";`,
+			`+dns = "9.9.9.9";`,
+		}
+		if !reflect.DeepEqual(lines, want) {
+			t.Errorf("got %q, want %q", lines, want)
+		}
+		return
+	}
+	t.Error("ar7.cfg is not reported as modified")
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"a b c", "a b c", ""},
+		{"a b c", "a x c", " a -b +x  c"},
+		{"a b c", "a b c d", " a  b  c +d"},
+		{"a b c", "b c", "-a  b  c"},
+		{"1 2 3 4 5 6 7 8 9", "1 2 3 4 x 6 7 8 9", " 2  3  4 -5 +x  6  7  8  …"},
+		{"x 1 2 3 4 5 6 7 8 y", "1 2 3 4 5 6 7 8", "-x  1  2  3  …  6  7  8 -y"},
+	}
+	for _, test := range tests {
+		lines, ok := diffLines(strings.Fields(test.a), strings.Fields(test.b))
+		if !ok {
+			t.Errorf("%q -> %q: diff gave up", test.a, test.b)
+			continue
+		}
+		var ops []string
+		for _, op := range lines {
+			ops = append(ops, string(op.Op)+op.Line)
+		}
+		if got := strings.Join(ops, " "); got != test.want {
+			t.Errorf("%q -> %q: got %q, want %q", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestDiffLinesLimit(t *testing.T) {
+	a := make([]string, maxDiffEdits)
+	b := make([]string, maxDiffEdits)
+	for i := range a {
+		a[i] = fmt.Sprintf("a%d", i)
+		b[i] = fmt.Sprintf("b%d", i)
+	}
+	if lines, ok := diffLines(a, b); ok {
+		t.Errorf("got %d lines for %d changed lines, want to give up", len(lines), 2*maxDiffEdits)
+	}
+	if _, ok := diffLines(a[:maxDiffEdits/2], b[:maxDiffEdits/2]); !ok {
+		t.Errorf("gave up on %d changed lines", maxDiffEdits)
+	}
+
+	old := &Export{Sections: []Section{{Name: "ar7.cfg", Type: "CFGFILE", Content: []byte(strings.Join(a, "\n"))}}}
+	current := &Export{Sections: []Section{{Name: "ar7.cfg", Type: "CFGFILE", Content: []byte(strings.Join(b, "\n"))}}}
+	want := []Change{{Kind: Modified, Section: true, Name: "ar7.cfg", Large: true}}
+	if changes := Diff(old, current); !reflect.DeepEqual(changes, want) {
+		t.Errorf("got %+v, want %+v", changes, want)
+	}
+}
+
+func TestDiffEmptyVariables(t *testing.T) {
+	a := &Export{Variables: []Variable{{Name: "OEM", Value: ""}, {Name: "Country", Value: "049"}}}
+	b := &Export{Variables: []Variable{{Name: "OEM", Value: ""}, {Name: "Language", Value: ""}}}
+	want := []Change{
+		{Kind: Removed, Name: "Country", Old: "049"},
+		{Kind: Added, Name: "Language"},
+	}
+	if changes := Diff(a, b); !reflect.DeepEqual(changes, want) {
+		t.Errorf("got %+v, want %+v", changes, want)
+	}
+}
@@ -0,0 +1,202 @@
+// Package export parses the configuration export files of a FRITZ!Box,
+// verifies their checksum, decrypts their secrets and compares exports, all
+// without a connection to the box.
+//
+// An export starts with a header line naming the model, followed by
+// variables like the firmware version, the sections with the configuration
+// files and a trailer with the CRC32 checksum:
+//
+//	**** FRITZ!Box 7590 CONFIGURATION EXPORT
+//	Password=$$$$…
+//	FirmwareVersion=154.07.57
+//	**** CFGFILE:ar7.cfg
+//	…
+//	**** END OF FILE ****
+//	**** END OF EXPORT 1A2B3C4D ****
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNotAnExport      = errors.New("not a FRITZ!Box configuration export")
+	ErrTruncated        = errors.New("configuration export is truncated")
+	ErrChecksumMismatch = errors.New("configuration export checksum mismatch")
+)
+
+var (
+	headerPattern   = regexp.MustCompile(`^\*{4} (.+) CONFIGURATION EXPORT$`)
+	sectionPattern  = regexp.MustCompile(`^\*{4} (CRYPTEDBINFILE|BINFILE|CFGFILE):(\S+)`)
+	endFilePattern  = regexp.MustCompile(`^\*{4} END OF FILE`)
+	trailerPattern  = regexp.MustCompile(`^\*{4} END OF EXPORT ([0-9A-Fa-f]{8})`)
+	variablePattern = regexp.MustCompile(`^(\w+)=(\S*)$`)
+)
+
+// Variable is a variable of the export header.
+type Variable struct {
+	Name  string
+	Value string
+}
+
+// Section is a configuration file contained in the export.
+type Section struct {
+	Name string
+	// Type is CFGFILE for text files, BINFILE for hex encoded binary files
+	// and CRYPTEDBINFILE for encrypted binary files.
+	Type string
+	// Content is the text of a CFGFILE as stored in the export and the
+	// decoded data of binary files.
+	Content []byte
+}
+
+// Text reports whether the section is a text file.
+func (s Section) Text() bool {
+	return s.Type == "CFGFILE"
+}
+
+// Export is a parsed configuration export.
+type Export struct {
+	Model     string
+	Variables []Variable
+	Sections  []Section
+	// Checksum is the checksum stated in the trailer, Computed the checksum
+	// of the content.
+	Checksum uint32
+	Computed uint32
+}
+
+// Variable returns the value of a header variable.
+func (e *Export) Variable(name string) string {
+	value, _ := e.LookupVariable(name)
+	return value
+}
+
+// LookupVariable returns the value of a header variable and whether the
+// export contains it, as variables may be empty.
+func (e *Export) LookupVariable(name string) (string, bool) {
+	for _, variable := range e.Variables {
+		if variable.Name == name {
+			return variable.Value, true
+		}
+	}
+	return "", false
+}
+
+// Section returns the configuration file with the given name, e.g. ar7.cfg.
+func (e *Export) Section(name string) (Section, bool) {
+	for _, section := range e.Sections {
+		if section.Name == name {
+			return section, true
+		}
+	}
+	return Section{}, false
+}
+
+// FirmwareVersion returns the firmware version the export was created with.
+func (e *Export) FirmwareVersion() string {
+	return e.Variable("FirmwareVersion")
+}
+
+// checksumWriter accumulates the checksum the way the box computes it: names
+// and values of header variables and section names are terminated by a NUL
+// byte, text sections are added without their final line break and with
+// escaped backslashes unescaped, binary sections are hex decoded.
+type checksumWriter struct {
+	hash hash.Hash32
+}
+
+func (w checksumWriter) variable(name string, value string) {
+	_, _ = w.hash.Write([]byte(name + value + "\x00"))
+}
+
+func (w checksumWriter) section(name string) {
+	_, _ = w.hash.Write([]byte(name + "\x00"))
+}
+
+func (w checksumWriter) content(section Section) {
+	if section.Text() {
+		content := strings.TrimSuffix(string(section.Content), "\n")
+		_, _ = w.hash.Write([]byte(strings.ReplaceAll(content, `\\`, `\`)))
+		return
+	}
+	_, _ = w.hash.Write(section.Content)
+}
+
+// Parse parses a configuration export. It fails if the data does not look
+// like an export or is truncated; a checksum mismatch is reported by Verify.
+func Parse(data []byte) (*Export, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+
+	if !scanner.Scan() {
+		return nil, ErrNotAnExport
+	}
+	matches := headerPattern.FindStringSubmatch(strings.TrimRight(scanner.Text(), "\r"))
+	if matches == nil {
+		return nil, ErrNotAnExport
+	}
+	export := &Export{Model: matches[1]}
+	checksum := checksumWriter{hash: crc32.NewIEEE()}
+
+	var section *Section
+	var content strings.Builder
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case section != nil && endFilePattern.MatchString(line):
+			if section.Text() {
+				section.Content = []byte(content.String())
+			} else {
+				data, err := hex.DecodeString(content.String())
+				if err != nil {
+					return nil, fmt.Errorf("%s: invalid binary section: %w", section.Name, err)
+				}
+				section.Content = data
+			}
+			checksum.content(*section)
+			export.Sections = append(export.Sections, *section)
+			section = nil
+		case section != nil && section.Text():
+			content.WriteString(line)
+			content.WriteByte('\n')
+		case section != nil:
+			content.WriteString(strings.TrimSpace(line))
+		case trailerPattern.MatchString(line):
+			value, _ := strconv.ParseUint(trailerPattern.FindStringSubmatch(line)[1], 16, 32)
+			export.Checksum = uint32(value)
+			export.Computed = checksum.hash.Sum32()
+			return export, nil
+		case sectionPattern.MatchString(line):
+			matches = sectionPattern.FindStringSubmatch(line)
+			section = &Section{Type: matches[1], Name: matches[2]}
+			content.Reset()
+			checksum.section(section.Name)
+		case variablePattern.MatchString(line):
+			matches = variablePattern.FindStringSubmatch(line)
+			export.Variables = append(export.Variables, Variable{Name: matches[1], Value: matches[2]})
+			checksum.variable(matches[1], matches[2])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrTruncated
+}
+
+// Verify checks that the content matches the checksum in the trailer.
+func (e *Export) Verify() error {
+	if e.Checksum != e.Computed {
+		return fmt.Errorf("%w: expected %08X, computed %08X", ErrChecksumMismatch, e.Checksum, e.Computed)
+	}
+	return nil
+}
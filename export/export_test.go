@@ -0,0 +1,133 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readExport reads a fixture from testdata. fritzbox.export and
+// fritzbox-updated.export were created with the export password
+// "export-secret".
+func readExport(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func parseExport(t *testing.T, name string) *Export {
+	t.Helper()
+	export, err := Parse(readExport(t, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return export
+}
+
+func TestParse(t *testing.T) {
+	export := parseExport(t, "fritzbox.export")
+	if export.Model != "FRITZ!Box 7590" {
+		t.Errorf("model is %q", export.Model)
+	}
+	if export.FirmwareVersion() != "154.07.57" {
+		t.Errorf("firmware version is %q", export.FirmwareVersion())
+	}
+	if export.Variable("CONFIG_INSTALL_TYPE") != "x86_5587" || export.Variable("Missing") != "" {
+		t.Errorf("unexpected variables %v", export.Variables)
+	}
+	if export.Checksum != 0x8345C8E2 {
+		t.Errorf("checksum is %08X", export.Checksum)
+	}
+	if err := export.Verify(); err != nil {
+		t.Error(err)
+	}
+
+	var names []string
+	for _, section := range export.Sections {
+		names = append(names, section.Type+":"+section.Name)
+	}
+	if len(names) != 3 || names[0] != "CFGFILE:ar7.cfg" || names[1] != "CFGFILE:voip.cfg" || names[2] != "BINFILE:phonebook.bin" {
+		t.Errorf("sections are %v", names)
+	}
+	if section, _ := export.Section("voip.cfg"); string(section.Content) != "voipcfg {\n        enabled = yes;\n}\n" {
+		t.Errorf("voip.cfg is %q", section.Content)
+	}
+	if section, _ := export.Section("ar7.cfg"); !bytes.Contains(section.Content, []byte(`path = "C:\\Users\\admin";`)) {
+		t.Errorf("ar7.cfg lost its escaped backslashes:\n%s", section.Content)
+	}
+	binary := make([]byte, 40)
+	for i := range binary {
+		binary[i] = byte(i)
+	}
+	if section, _ := export.Section("phonebook.bin"); !bytes.Equal(section.Content, binary) {
+		t.Errorf("phonebook.bin is %X", section.Content)
+	}
+}
+
+func TestParseCRLF(t *testing.T) {
+	export, err := Parse(bytes.ReplaceAll(readExport(t, "fritzbox.export"), []byte("\n"), []byte("\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = export.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyDetectsChanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+	}{
+		{"variable", "FirmwareVersion=154.07.57", "FirmwareVersion=154.07.58"},
+		{"section name", "CFGFILE:voip.cfg", "CFGFILE:voip2.cfg"},
+		{"text", "dsldmode_router", "dsldmode_bridge"},
+		{"escaped backslash", `C:\\Users`, `C:\\Usr`},
+		{"secret", "$$$$N2GZ", "$$$$N3GZ"},
+		{"binary", "0A0B0C", "0A0B0D"},
+		{"trailer", "END OF EXPORT 8345C8E2", "END OF EXPORT 8345C8E3"},
+	}
+	data := readExport(t, "fritzbox.export")
+	for _, test := range tests {
+		changed := bytes.Replace(data, []byte(test.old), []byte(test.new), 1)
+		if bytes.Equal(changed, data) {
+			t.Fatalf("%s: %q not found in fixture", test.name, test.old)
+		}
+		export, err := Parse(changed)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if err = export.Verify(); !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("%s: expected checksum mismatch, got %v", test.name, err)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	data := readExport(t, "fritzbox.export")
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrNotAnExport},
+		{"other file", []byte("<html></html>\n"), ErrNotAnExport},
+		{"truncated", data[:bytes.Index(data, []byte("**** END OF EXPORT"))], ErrTruncated},
+		{"truncated section", data[:bytes.Index(data, []byte("**** CFGFILE:voip.cfg"))-1], ErrTruncated},
+	}
+	for _, test := range tests {
+		if _, err := Parse(test.data); !errors.Is(err, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, err)
+		}
+	}
+
+	if _, err := Parse(bytes.Replace(data, []byte("0A0B0C"), []byte("0A0B0X"), 1)); err == nil {
+		t.Error("invalid hex in a binary section was accepted")
+	}
+}
@@ -5,8 +5,22 @@ go 1.23.0
 require (
 	github.com/alexflint/go-arg v1.5.1
 	github.com/andybalholm/cascadia v1.3.2
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/letsencrypt/pebble/v2 v2.6.0
+	github.com/miekg/dns v1.1.62
+	golang.org/x/crypto v0.27.0
 	golang.org/x/net v0.29.0
 	golang.org/x/text v0.18.0
+	gopkg.in/yaml.v3 v3.0.1
+	software.sslmate.com/src/go-pkcs12 v0.5.0
 )
 
-require github.com/alexflint/go-scalar v1.2.0 // indirect
+require (
+	github.com/alexflint/go-scalar v1.2.0 // indirect
+	github.com/go-jose/go-jose/v4 v4.0.1 // indirect
+	github.com/letsencrypt/challtestsrv v1.3.2 // indirect
+	golang.org/x/mod v0.18.0 // indirect
+	golang.org/x/sync v0.8.0 // indirect
+	golang.org/x/sys v0.25.0 // indirect
+	golang.org/x/tools v0.22.0 // indirect
+)
@@ -1,19 +1,154 @@
 package main
 
 import (
+	"bufio"
 	"errors"
+	"fmt"
+	"fritzbox-client/api"
 	"github.com/alexflint/go-arg"
+	"io"
 	"log"
 	"os"
+	"slices"
 	"strings"
 )
 
 type args struct {
-	Hostname string       `arg:"--host,required" placeholder:"host"`
-	Username string       `arg:"--user,required" placeholder:"user"`
-	Password string       `arg:"--pass,required" placeholder:"pass"`
-	Sip      *sipCommand  `arg:"subcommand:sip"`
-	Cert     *certCommand `arg:"subcommand:cert"`
+	Hostname    string              `arg:"--host" placeholder:"host"`
+	Username    string              `arg:"--user" placeholder:"user"`
+	Password    string              `arg:"--pass" placeholder:"pass"`
+	Sip         *sipCommand         `arg:"subcommand:sip"`
+	Cert        *certCommand        `arg:"subcommand:cert"`
+	Backup      *backupCommand      `arg:"subcommand:backup"`
+	Firmware    *firmwareCommand    `arg:"subcommand:firmware"`
+	Reboot      *rebootCommand      `arg:"subcommand:reboot"`
+	Info        *infoCommand        `arg:"subcommand:info"`
+	Hosts       *hostsCommand       `arg:"subcommand:hosts"`
+	Wol         *wolCommand         `arg:"subcommand:wol"`
+	PortForward *portForwardCommand `arg:"subcommand:portforward"`
+}
+
+// needsHost reports whether the command talks to the box given with --host.
+// Exports are inspected and compared offline, and cert check only falls back
+// to --host without hosts.
+func (a *args) needsHost() bool {
+	switch {
+	case a.Backup != nil && (a.Backup.Inspect != nil || a.Backup.Diff != nil):
+		return false
+	case a.Cert != nil && a.Cert.Check != nil && len(a.Cert.Check.Hosts) > 0:
+		return false
+	}
+	return true
+}
+
+// exitStatus is returned by commands that have to exit with a specific code.
+type exitStatus int
+
+func (e exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+func exitCode(err error) int {
+	var status exitStatus
+	if errors.As(err, &status) {
+		return int(status)
+	}
+	return 1
+}
+
+func login(options args) (api.FritzboxClient, api.SessionInfo, error) {
+	return loginReporting(options, os.Stdout)
+}
+
+// loginReporting logs in like login, writing its progress messages to
+// progress.
+func loginReporting(options args, progress io.Writer) (api.FritzboxClient, api.SessionInfo, error) {
+	var err error
+
+	if options.Username == "" || options.Password == "" {
+		err = errors.New("--user and --pass are required for this command")
+		_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+		return api.FritzboxClient{}, api.SessionInfo{}, err
+	}
+
+	var client api.FritzboxClient
+	if client, err = api.NewClient(options.Hostname); err != nil {
+		return client, api.SessionInfo{}, err
+	}
+
+	_, _ = fmt.Fprintf(progress, "Logging in to %s as %s… ", options.Hostname, options.Username)
+	var sessionInfo api.SessionInfo
+	if sessionInfo, err = client.Login(options.Username, options.Password); err != nil {
+		_, _ = fmt.Fprintf(progress, "Error: %s\n", err.Error())
+		return client, sessionInfo, err
+	}
+	_, _ = fmt.Fprintln(progress, "Done.")
+
+	return client, sessionInfo, nil
+}
+
+// readSecret reads a secret from file:PATH, env:NAME or stdin, so that it never
+// has to be passed on the command line.
+func readSecret(source string) (string, error) {
+	switch {
+	case strings.HasPrefix(source, "file:"):
+		data, err := os.ReadFile(strings.TrimPrefix(source, "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(source, "env:"):
+		name := strings.TrimPrefix(source, "env:")
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+	case source == "stdin" || source == "-":
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	default:
+		return "", fmt.Errorf("unknown secret source %q, expected file:PATH, env:NAME or stdin", source)
+	}
+}
+
+// legacyArguments keeps `cert path_key path_cert [pass_key]`, which is used in
+// countless ACME hooks, working by inserting the install subcommand. The
+// tasks of `sip`, which used to be matched case-insensitively, are lowercased
+// to the names of their subcommands.
+func legacyArguments(arguments []string) []string {
+	for i := 0; i < len(arguments); i++ {
+		switch arguments[i] {
+		case "--host", "--user", "--pass":
+			i++
+		case "cert":
+			if i+1 >= len(arguments) {
+				return arguments
+			}
+			switch arguments[i+1] {
+			case "install", "show", "export", "check", "acme", "watch", "-h", "--help":
+				return arguments
+			}
+			return slices.Concat(arguments[:i+1], []string{"install"}, arguments[i+1:])
+		case "sip":
+			if i+1 >= len(arguments) {
+				return arguments
+			}
+			switch task := strings.ToLower(arguments[i+1]); task {
+			case "connect", "disconnect", "reconnect":
+				return slices.Concat(arguments[:i+1], []string{task}, arguments[i+2:])
+			}
+			return arguments
+		default:
+			if !strings.HasPrefix(arguments[i], "-") {
+				return arguments
+			}
+		}
+	}
+	return arguments
 }
 
 func main() {
@@ -23,7 +158,7 @@ func main() {
 		log.Fatalf("there was an error in the definition of the Go struct: %v", err)
 	}
 
-	err = p.Parse(os.Args[1:])
+	err = p.Parse(legacyArguments(os.Args[1:]))
 	switch {
 	case errors.Is(err, arg.ErrHelp):
 		_ = p.WriteHelpForSubcommand(os.Stdout, p.SubcommandNames()...)
@@ -31,15 +166,47 @@ func main() {
 	case err != nil:
 		_ = p.WriteUsageForSubcommand(os.Stdout, p.SubcommandNames()...)
 		os.Exit(64)
+	case p.Subcommand() != nil && args.Hostname == "" && args.needsHost():
+		_ = p.WriteUsageForSubcommand(os.Stdout, p.SubcommandNames()...)
+		fmt.Println("error: --host is required for this command")
+		os.Exit(64)
 	}
 
-	if args.Sip != nil && (strings.EqualFold(args.Sip.Task, "reconnect") || strings.EqualFold(args.Sip.Task, "disconnect") || strings.EqualFold(args.Sip.Task, "connect")) {
+	if args.Sip != nil && args.Sip.task() != "" {
 		if err := commandSip(args); err != nil {
-			os.Exit(1)
+			os.Exit(exitCode(err))
 		}
-	} else if args.Cert != nil {
+	} else if args.Cert != nil && args.Cert.task() != "" {
 		if err := commandCert(args); err != nil {
-			os.Exit(1)
+			os.Exit(exitCode(err))
+		}
+	} else if args.Backup != nil && args.Backup.task() != "" {
+		if err := commandBackup(args); err != nil {
+			os.Exit(exitCode(err))
+		}
+	} else if args.Firmware != nil && args.Firmware.task() != "" {
+		if err := commandFirmware(args); err != nil {
+			os.Exit(exitCode(err))
+		}
+	} else if args.Reboot != nil {
+		if err := commandReboot(args); err != nil {
+			os.Exit(exitCode(err))
+		}
+	} else if args.Info != nil {
+		if err := commandInfo(args); err != nil {
+			os.Exit(exitCode(err))
+		}
+	} else if args.Hosts != nil && args.Hosts.task() != "" {
+		if err := commandHosts(args); err != nil {
+			os.Exit(exitCode(err))
+		}
+	} else if args.Wol != nil {
+		if err := commandWol(args); err != nil {
+			os.Exit(exitCode(err))
+		}
+	} else if args.PortForward != nil && args.PortForward.task() != "" {
+		if err := commandPortForward(args); err != nil {
+			os.Exit(exitCode(err))
 		}
 	} else {
 		_ = p.WriteHelpForSubcommand(os.Stdout, p.SubcommandNames()...)